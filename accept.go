@@ -0,0 +1,141 @@
+package gzip
+
+import (
+	"hash/maphash"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// acceptEncoding holds the qvalues an Accept-Encoding header gives the codings
+// the middleware cares about, -1 for codings it does not list.
+type acceptEncoding struct {
+	gzip     float64
+	br       float64
+	dcz      float64
+	identity float64
+	any      float64
+}
+
+// parseAcceptEncoding parses Accept-Encoding header values as RFC 9110
+// section 12.5.3 describes: codings are case-insensitive, x-gzip is an alias
+// of gzip and the qvalue defaults to 1. Elements with a malformed qvalue are
+// ignored.
+func parseAcceptEncoding(values []string) acceptEncoding {
+	a := acceptEncoding{gzip: -1, br: -1, dcz: -1, identity: -1, any: -1}
+	for _, value := range values {
+		for rest, more := value, true; more; {
+			var element string
+			element, rest, more = strings.Cut(rest, ",")
+			coding, params, _ := strings.Cut(element, ";")
+			q, ok := parseQValue(params)
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(strings.Trim(coding, " \t")) {
+			case "gzip", "x-gzip":
+				a.gzip = max(a.gzip, q)
+			case "br":
+				a.br = max(a.br, q)
+			case "dcz":
+				a.dcz = max(a.dcz, q)
+			case "identity":
+				a.identity = max(a.identity, q)
+			case "*":
+				a.any = max(a.any, q)
+			}
+		}
+	}
+	return a
+}
+
+// acceptCacheSize is the number of slots of acceptCache, and
+// maxCachedAcceptEncoding the longest header value it holds.
+const (
+	acceptCacheSize         = 256
+	maxCachedAcceptEncoding = 256
+)
+
+// acceptCache memoizes parsed Accept-Encoding values. Clients on keep-alive
+// connections, and most clients of one service, send the same few values
+// over and over. Each value maps to one slot, overwriting whatever was there:
+// the cache stays bounded and a lookup is a hash and an atomic load.
+var (
+	acceptCache     [acceptCacheSize]atomic.Pointer[acceptCacheEntry]
+	acceptCacheSeed = maphash.MakeSeed()
+)
+
+type acceptCacheEntry struct {
+	value  string
+	accept acceptEncoding
+}
+
+// cachedAcceptEncoding is parseAcceptEncoding going through acceptCache for
+// requests carrying a single, short Accept-Encoding header, except in
+// gzipminimal builds.
+func cachedAcceptEncoding(values []string) acceptEncoding {
+	if minimalBuild || len(values) != 1 || len(values[0]) > maxCachedAcceptEncoding {
+		return parseAcceptEncoding(values)
+	}
+	value := values[0]
+	slot := &acceptCache[maphash.String(acceptCacheSeed, value)%acceptCacheSize]
+	if e := slot.Load(); e != nil && e.value == value {
+		return e.accept
+	}
+	accept := parseAcceptEncoding(values)
+	slot.Store(&acceptCacheEntry{value: value, accept: accept})
+	return accept
+}
+
+// parseQValue returns the weight among the parameters of a list element.
+func parseQValue(params string) (float64, bool) {
+	q := 1.0
+	for rest, more := params, params != ""; more; {
+		var param string
+		param, rest, more = strings.Cut(rest, ";")
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.Trim(name, " \t"), "q") {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.Trim(value, " \t"), 64)
+		if err != nil || v < 0 || v > 1 {
+			return 0, false
+		}
+		q = v
+	}
+	return q, true
+}
+
+// allowsGzip reports whether a gzip coded response is acceptable. A listed
+// gzip wins over the wildcard, which only counts when wildcard is set.
+func (a acceptEncoding) allowsGzip(wildcard bool) bool {
+	return a.allows(a.gzip, wildcard)
+}
+
+// allowsBrotli reports whether a br coded response is acceptable, like
+// allowsGzip.
+func (a acceptEncoding) allowsBrotli(wildcard bool) bool {
+	return a.allows(a.br, wildcard)
+}
+
+// allowsDictionary reports whether a dcz coded response is acceptable. The
+// wildcard does not count: clients list the dictionary codings they support.
+func (a acceptEncoding) allowsDictionary() bool {
+	return a.dcz > 0
+}
+
+func (a acceptEncoding) allows(q float64, wildcard bool) bool {
+	if q >= 0 {
+		return q > 0
+	}
+	return wildcard && a.any > 0
+}
+
+// forbidsIdentity reports whether an unencoded response is unacceptable,
+// through "identity;q=0" or a "*;q=0" not overridden for identity.
+func (a acceptEncoding) forbidsIdentity() bool {
+	if a.identity >= 0 {
+		return a.identity == 0
+	}
+	return a.any == 0
+}
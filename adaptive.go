@@ -0,0 +1,57 @@
+package gzip
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync/atomic"
+)
+
+// adaptivePools holds one writer pool per level between Options.AdaptiveMinLevel
+// and Options.AdaptiveMaxLevel and picks one per request from the current load.
+type adaptivePools struct {
+	min      int
+	pools    []*writerPool
+	probe    func() float64
+	inFlight atomic.Int64
+}
+
+func newAdaptivePools(minLevel, maxLevel int, newPool func(level int) (*writerPool, error),
+	probe func() float64,
+) (*adaptivePools, error) {
+	a := &adaptivePools{min: minLevel, probe: probe}
+	for level := minLevel; level <= maxLevel; level++ {
+		pool, err := newPool(level)
+		if err != nil {
+			return nil, err
+		}
+		a.pools = append(a.pools, pool)
+	}
+	if a.probe == nil {
+		a.probe = a.concurrencyLoad
+	}
+	return a, nil
+}
+
+// validateAdaptiveLevels reports adaptive ranges outside BestSpeed to
+// BestCompression or with the bounds swapped.
+func validateAdaptiveLevels(minLevel, maxLevel int) error {
+	if minLevel < BestSpeed || maxLevel > BestCompression || minLevel > maxLevel {
+		return fmt.Errorf("gzip: invalid adaptive level range %d-%d", minLevel, maxLevel)
+	}
+	return nil
+}
+
+// concurrencyLoad is the default load probe: the number of responses being
+// compressed per available CPU.
+func (a *adaptivePools) concurrencyLoad() float64 {
+	return float64(a.inFlight.Load()) / float64(runtime.GOMAXPROCS(0))
+}
+
+// pool returns the pool for the level matching the current load, moving from
+// the highest level when idle down to the lowest once the load reaches 1.
+func (a *adaptivePools) pool() *writerPool {
+	load := math.Min(math.Max(a.probe(), 0), 1)
+	steps := float64(len(a.pools) - 1)
+	return a.pools[len(a.pools)-1-int(math.Round(load*steps))]
+}
@@ -0,0 +1,26 @@
+//go:build !klauspost
+
+package gzip
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// backendName identifies the compression package in use; build with
+// -tags klauspost to switch to github.com/klauspost/compress/gzip.
+const backendName = "compress/gzip"
+
+type (
+	compressor   = gzip.Writer
+	decompressor = gzip.Reader
+	gzipHeader   = gzip.Header
+)
+
+func newCompressor(w io.Writer, level int) (*compressor, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+func newDecompressor(r io.Reader) (*decompressor, error) {
+	return gzip.NewReader(r)
+}
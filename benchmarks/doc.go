@@ -0,0 +1,6 @@
+// Package benchmarks measures the middleware end to end, through gin, so
+// performance regressions in the write path show up in one place:
+//
+//	go test -bench . -benchmem ./benchmarks
+//	go test -tags klauspost -bench . -benchmem ./benchmarks
+package benchmarks
@@ -0,0 +1,82 @@
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/gzip"
+	"github.com/gin-gonic/gin"
+)
+
+// jsonBody returns a JSON lines body of at least size bytes.
+func jsonBody(size int) string {
+	var sb strings.Builder
+	for i := 0; sb.Len() < size; i++ {
+		fmt.Fprintf(&sb, `{"id":%d,"name":"item-%d","tags":["a","b"]}`+"\n", i, i*7)
+	}
+	return sb.String()
+}
+
+func BenchmarkGzip(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+
+	small := jsonBody(1 << 10)
+	large := jsonBody(1 << 20)
+	event := jsonBody(256)
+	const events = 64
+
+	router := gin.New()
+	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	router.GET("/small", func(c *gin.Context) {
+		c.String(http.StatusOK, small)
+	})
+	router.GET("/large", func(c *gin.Context) {
+		c.String(http.StatusOK, large)
+	})
+	router.GET("/streaming", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain")
+		for i := 0; i < events; i++ {
+			_, _ = c.Writer.WriteString(event)
+			c.Writer.Flush()
+		}
+	})
+	router.GET("/skipped", func(c *gin.Context) {
+		c.String(http.StatusOK, small)
+	})
+
+	for _, bm := range []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		size           int
+	}{
+		{"small", "/small", "gzip", len(small)},
+		{"large", "/large", "gzip", len(large)},
+		{"streaming", "/streaming", "gzip", len(event) * events},
+		{"skipped", "/skipped", "br", len(small)},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", bm.path, nil)
+		req.Header.Set("Accept-Encoding", bm.acceptEncoding)
+
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(bm.size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				router.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+		b.Run(bm.name+"/parallel", func(b *testing.B) {
+			b.SetBytes(int64(bm.size))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					router.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			})
+		})
+	}
+}
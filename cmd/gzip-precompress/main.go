@@ -0,0 +1,29 @@
+// Command gzip-precompress writes a <name>.gz sibling for the files under the
+// given directories, for gzip.StaticFS to serve once embedded, e.g.
+//
+//	//go:generate go run github.com/gin-contrib/gzip/cmd/gzip-precompress assets
+//
+// Up to date siblings are kept; pass -rebuild to rewrite them all, e.g. after
+// changing -level, and -sync to flush every sibling to disk before renaming it
+// into place.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/gin-contrib/gzip"
+)
+
+func main() {
+	level := flag.Int("level", gzip.BestCompression, "compression level")
+	rebuild := flag.Bool("rebuild", false, "rewrite siblings that are up to date")
+	sync := flag.Bool("sync", false, "flush siblings to disk before renaming them into place")
+	flag.Parse()
+	opts := gzip.PrecompressOptions{Level: *level, Rebuild: *rebuild, Sync: *sync}
+	for _, root := range flag.Args() {
+		if err := gzip.PrecompressDirWithOptions(root, opts); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package gzip
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler is the middleware as a value that can be reconfigured while
+// serving, see Handle and Reconfigure. It also exposes the steps of the
+// middleware, for middleware of your own that combines compression with
+// other concerns such as caching. Handle runs all of them, along with
+// precompressed files, concurrency limits and strict negotiation, which the
+// steps leave to the caller.
+type Handler struct {
+	level int
+	// current is swapped by Reconfigure. Each request loads it once and
+	// keeps that configuration until its response is finished.
+	current atomic.Pointer[gzipHandler]
+	mu      sync.Mutex
+}
+
+// NewHandler returns a Handler with the level and options Gzip takes,
+// reporting invalid ones like New.
+func NewHandler(level int, options ...Option) (*Handler, error) {
+	g, err := buildGzipHandler(level, options...)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{level: level}
+	h.current.Store(g)
+	return h, nil
+}
+
+// reset replaces the configuration of h with the one level and options
+// describe, starting from the defaults.
+func (h *Handler) reset(level int, options ...Option) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	g, err := buildGzipHandler(level, options...)
+	if err != nil {
+		return err
+	}
+	h.level = level
+	h.current.Store(g)
+	return nil
+}
+
+// Handle is the middleware, like the function Gzip returns, following the
+// configuration set by Reconfigure.
+func (h *Handler) Handle(c *gin.Context) {
+	h.current.Load().Handle(c)
+}
+
+// Reconfigure applies options on top of the current configuration, e.g. to
+// change exclusions, the level or MinLength from a feature flag system while
+// serving. Requests already started finish with the configuration they began
+// with. Invalid options are reported like New and leave the configuration as
+// it was. Reconfigure may be called concurrently with requests.
+func (h *Handler) Reconfigure(options ...Option) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	g, err := compileGzipHandler(h.level, *h.current.Load().Options, options...)
+	if err != nil {
+		return err
+	}
+	h.current.Store(g)
+	return nil
+}
+
+// ShouldCompress runs the request pipeline of the middleware and reports
+// whether the response to req may be compressed. Stages looking at
+// the gin context see one holding only req, with no route and no keys.
+func (h *Handler) ShouldCompress(req *http.Request) bool {
+	return h.current.Load().requestSkipReason(&gin.Context{Request: req}) == ""
+}
+
+// WrapWriter returns a writer compressing what is written to it into w,
+// deciding on the first write with the response stages like the middleware.
+// The returned writer implements io.Closer: Close must be called once the
+// response is written, to end the gzip stream and reuse the compressor.
+// Writers made by WrapWriter know nothing of the request, so EventHook, the
+// response stages and WithTrustedRequest get a gin context holding an empty
+// one, which no trusted network matches.
+func (h *Handler) WrapWriter(w gin.ResponseWriter) gin.ResponseWriter {
+	c := &gin.Context{Request: &http.Request{
+		URL:        &url.URL{},
+		Header:     make(http.Header),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}}
+	return &closingWriter{h.current.Load().newWriter(c, w)}
+}
+
+// Decompress decodes a gzip encoded request body in place with the
+// configured DecompressFn, if any, and its parts with
+// WithMultipartDecompression. It returns the error of a body that cannot be
+// decoded, after reporting it like the middleware does.
+func (h *Handler) Decompress(c *gin.Context) error {
+	return h.current.Load().decompress(c)
+}
+
+// closingWriter is the gzipWriter handed out by WrapWriter.
+type closingWriter struct {
+	*gzipWriter
+}
+
+// Close finishes the response. Calling it again does nothing.
+func (w *closingWriter) Close() error {
+	if w.pool == nil {
+		return nil
+	}
+	_, err := w.finish()
+	return err
+}
@@ -0,0 +1,214 @@
+package gzip
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config is the plain-data form of the middleware options, for building it
+// from configuration files with NewWithConfig or NewFromConfigFile. Nil
+// lists keep the defaults, empty lists clear them.
+type Config struct {
+	// Level defaults to DefaultCompression when nil.
+	Level      *int           `json:"level,omitempty" yaml:"level,omitempty"`
+	LevelRules map[string]int `json:"level_rules,omitempty" yaml:"level_rules,omitempty"`
+
+	ExcludedExtensions           []string `json:"excluded_extensions,omitempty" yaml:"excluded_extensions,omitempty"`
+	AdditionalExcludedExtensions []string `json:"additional_excluded_extensions,omitempty" yaml:"additional_excluded_extensions,omitempty"` //nolint:lll // struct tags cannot be wrapped
+	ExcludedPaths                []string `json:"excluded_paths,omitempty" yaml:"excluded_paths,omitempty"`
+	ExcludedPathsRegexs          []string `json:"excluded_paths_regexs,omitempty" yaml:"excluded_paths_regexs,omitempty"`
+	ExcludedMethods              []string `json:"excluded_methods,omitempty" yaml:"excluded_methods,omitempty"`
+	IncludedPaths                []string `json:"included_paths,omitempty" yaml:"included_paths,omitempty"`
+	IncludedPathsRegexs          []string `json:"included_paths_regexs,omitempty" yaml:"included_paths_regexs,omitempty"`
+	ExcludedRoutes               []string `json:"excluded_routes,omitempty" yaml:"excluded_routes,omitempty"`
+	ExcludedContentTypes         []string `json:"excluded_content_types,omitempty" yaml:"excluded_content_types,omitempty"`
+	IncludedContentTypes         []string `json:"included_content_types,omitempty" yaml:"included_content_types,omitempty"`
+	ExcludedStatusCodes          []int    `json:"excluded_status_codes,omitempty" yaml:"excluded_status_codes,omitempty"`
+
+	ExcludedQueryParams map[string][]string `json:"excluded_query_params,omitempty" yaml:"excluded_query_params,omitempty"`
+	RequestHeaderRules  []RequestHeaderRule `json:"request_header_rules,omitempty" yaml:"request_header_rules,omitempty"`
+	SkipInternal        []string            `json:"skip_internal,omitempty" yaml:"skip_internal,omitempty"`
+	TrustedNetworks     []string            `json:"trusted_networks,omitempty" yaml:"trusted_networks,omitempty"`
+
+	// Decompress installs DefaultDecompressHandle for gzip-encoded requests.
+	Decompress           bool `json:"decompress,omitempty" yaml:"decompress,omitempty"`
+	RejectMultistream    bool `json:"reject_multistream,omitempty" yaml:"reject_multistream,omitempty"`
+	LenientDecompression bool `json:"lenient_decompression,omitempty" yaml:"lenient_decompression,omitempty"`
+
+	CaseInsensitiveMatching bool `json:"case_insensitive_matching,omitempty" yaml:"case_insensitive_matching,omitempty"`
+	CompressRangeRequests   bool `json:"compress_range_requests,omitempty" yaml:"compress_range_requests,omitempty"`
+	CompressUpgradeRequests bool `json:"compress_upgrade_requests,omitempty" yaml:"compress_upgrade_requests,omitempty"`
+	SkipHTTP10              bool `json:"skip_http10,omitempty" yaml:"skip_http10,omitempty"`
+	HTTP10BufferSize        int  `json:"http10_buffer_size,omitempty" yaml:"http10_buffer_size,omitempty"`
+	ContentSniffing         bool `json:"content_sniffing,omitempty" yaml:"content_sniffing,omitempty"`
+	MinLength               int  `json:"min_length,omitempty" yaml:"min_length,omitempty"`
+	Deterministic           bool `json:"deterministic,omitempty" yaml:"deterministic,omitempty"`
+
+	Stats                bool   `json:"stats,omitempty" yaml:"stats,omitempty"`
+	StatsTrailers        bool   `json:"stats_trailers,omitempty" yaml:"stats_trailers,omitempty"`
+	LengthTrailer        string `json:"length_trailer,omitempty" yaml:"length_trailer,omitempty"`
+	OriginalLengthHeader string `json:"original_length_header,omitempty" yaml:"original_length_header,omitempty"`
+
+	AdaptiveMinLevel int        `json:"adaptive_min_level,omitempty" yaml:"adaptive_min_level,omitempty"`
+	AdaptiveMaxLevel int        `json:"adaptive_max_level,omitempty" yaml:"adaptive_max_level,omitempty"`
+	Pool             PoolConfig `json:"pool,omitempty" yaml:"pool,omitempty"`
+
+	MaxConcurrentCompressions int `json:"max_concurrent_compressions,omitempty" yaml:"max_concurrent_compressions,omitempty"` //nolint:lll // struct tags cannot be wrapped
+}
+
+// NewWithConfig returns the middleware described by cfg, or an error listing
+// every invalid or conflicting setting instead of panicking like the
+// options do.
+func NewWithConfig(cfg Config) (gin.HandlerFunc, error) {
+	options, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg.level(), options...)
+}
+
+// level returns the configured level, DefaultCompression when unset.
+func (cfg Config) level() int {
+	if cfg.Level != nil {
+		return *cfg.Level
+	}
+	return DefaultCompression
+}
+
+// options validates cfg and translates it into options.
+func (cfg Config) options() ([]Option, error) {
+	var errs []error
+	if cfg.Level != nil {
+		errs = append(errs, validateLevel(*cfg.Level))
+	}
+
+	regexs, err := compileRegexs("excluded", cfg.ExcludedPathsRegexs)
+	errs = append(errs, err)
+	includedRegexs, err := compileRegexs("included", cfg.IncludedPathsRegexs)
+	errs = append(errs, err)
+
+	_, err = newHeaderRules(cfg.RequestHeaderRules)
+	errs = append(errs, err)
+	_, err = newNetworks("internal", cfg.SkipInternal)
+	errs = append(errs, err)
+	_, err = newNetworks("trusted", cfg.TrustedNetworks)
+	errs = append(errs, err)
+
+	if cfg.AdaptiveMinLevel != 0 || cfg.AdaptiveMaxLevel != 0 {
+		errs = append(errs, validateAdaptiveLevels(cfg.AdaptiveMinLevel, cfg.AdaptiveMaxLevel))
+	}
+	if cfg.SkipHTTP10 && cfg.HTTP10BufferSize > 0 {
+		errs = append(errs, errors.New("gzip: skip_http10 and http10_buffer_size are mutually exclusive"))
+	}
+	if cfg.RejectMultistream && !cfg.Decompress {
+		errs = append(errs, errors.New("gzip: reject_multistream requires decompress"))
+	}
+	if cfg.LenientDecompression && !cfg.Decompress {
+		errs = append(errs, errors.New("gzip: lenient_decompression requires decompress"))
+	}
+	if cfg.LengthTrailer != "" &&
+		http.CanonicalHeaderKey(cfg.LengthTrailer) == http.CanonicalHeaderKey(cfg.OriginalLengthHeader) {
+		errs = append(errs, fmt.Errorf("gzip: length_trailer and original_length_header both use %s",
+			cfg.LengthTrailer))
+	}
+	for name, n := range map[string]int{
+		"http10_buffer_size":          cfg.HTTP10BufferSize,
+		"max_concurrent_compressions": cfg.MaxConcurrentCompressions,
+		"min_length":                  cfg.MinLength,
+		"pool.initial_size":           cfg.Pool.InitialSize,
+		"pool.max_writers":            cfg.Pool.MaxWriters,
+		"pool.buffer_size":            cfg.Pool.BufferSize,
+	} {
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("gzip: %s must not be negative, got %d", name, n))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	options := []Option{func(o *Options) {
+		o.CaseInsensitiveMatching = cfg.CaseInsensitiveMatching
+		o.CompressRangeRequests = cfg.CompressRangeRequests
+		o.CompressUpgradeRequests = cfg.CompressUpgradeRequests
+		o.SkipHTTP10 = cfg.SkipHTTP10
+		o.HTTP10BufferSize = cfg.HTTP10BufferSize
+		o.ContentSniffing = cfg.ContentSniffing
+		o.MinLength = cfg.MinLength
+		o.Deterministic = cfg.Deterministic
+		o.Stats = cfg.Stats
+		o.StatsTrailers = cfg.StatsTrailers
+		o.RejectMultistream = cfg.RejectMultistream
+		o.LenientDecompression = cfg.LenientDecompression
+		o.OriginalLengthHeader = cfg.OriginalLengthHeader
+		o.AdaptiveMinLevel = cfg.AdaptiveMinLevel
+		o.AdaptiveMaxLevel = cfg.AdaptiveMaxLevel
+		o.MaxConcurrentCompressions = cfg.MaxConcurrentCompressions
+		o.PoolConfig = cfg.Pool
+		o.LevelRules = cfg.LevelRules
+		if cfg.ExcludedPathsRegexs != nil {
+			o.ExcludedPathesRegexs = regexs
+		}
+		if cfg.IncludedPaths != nil {
+			o.IncludedPaths = NewIncludedPaths(cfg.IncludedPaths)
+		}
+		if cfg.IncludedPathsRegexs != nil {
+			o.IncludedPathsRegexs = IncludedPathsRegexs(includedRegexs)
+		}
+	}}
+	if cfg.ExcludedExtensions != nil {
+		options = append(options, WithExcludedExtensions(cfg.ExcludedExtensions))
+	}
+	if cfg.AdditionalExcludedExtensions != nil {
+		options = append(options, WithAdditionalExcludedExtensions(cfg.AdditionalExcludedExtensions))
+	}
+	if cfg.ExcludedPaths != nil {
+		options = append(options, WithExcludedPaths(cfg.ExcludedPaths))
+	}
+	if cfg.ExcludedMethods != nil {
+		options = append(options, WithExcludedMethods(cfg.ExcludedMethods))
+	}
+	if cfg.ExcludedRoutes != nil {
+		options = append(options, WithExcludedRoutes(cfg.ExcludedRoutes))
+	}
+	if cfg.ExcludedQueryParams != nil {
+		options = append(options, WithExcludedQueryParams(cfg.ExcludedQueryParams))
+	}
+	if cfg.ExcludedContentTypes != nil {
+		options = append(options, WithExcludedContentTypes(cfg.ExcludedContentTypes))
+	}
+	if cfg.IncludedContentTypes != nil {
+		options = append(options, WithIncludedContentTypes(cfg.IncludedContentTypes))
+	}
+	if cfg.ExcludedStatusCodes != nil {
+		options = append(options, WithExcludedStatusCodes(cfg.ExcludedStatusCodes))
+	}
+	if cfg.RequestHeaderRules != nil {
+		options = append(options, WithRequestHeaderRules(cfg.RequestHeaderRules...))
+	}
+	if cfg.SkipInternal != nil {
+		options = append(options, WithSkipInternal(cfg.SkipInternal))
+	}
+	if cfg.TrustedNetworks != nil {
+		options = append(options, WithTrustedNetworks(cfg.TrustedNetworks))
+	}
+	if cfg.Decompress {
+		options = append(options, WithDecompressFn(DefaultDecompressHandle))
+	}
+	if cfg.LengthTrailer != "" {
+		options = append(options, WithLengthTrailer(cfg.LengthTrailer))
+	}
+	return options, nil
+}
+
+// validateLevel reports levels the gzip writer does not accept.
+func validateLevel(level int) error {
+	if level < HuffmanOnly || level > BestCompression {
+		return fmt.Errorf("gzip: invalid compression level %d, want %d to %d",
+			level, HuffmanOnly, BestCompression)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package gzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NewFromConfigFile returns a Handler configured from the Config in the file
+// at path, JSON when its name ends in .json and YAML otherwise. Unknown keys
+// are errors, so typos do not go unnoticed. See WatchConfigFile to reload it
+// when the file changes. Gzipminimal builds only read JSON files.
+func NewFromConfigFile(path string) (*Handler, error) {
+	return NewFromConfigFS(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+}
+
+// NewFromConfigFS is NewFromConfigFile reading the file name of fsys, e.g. an
+// embed.FS.
+func NewFromConfigFS(fsys fs.FS, name string) (*Handler, error) {
+	cfg, err := readConfigFS(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	options, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	return NewHandler(cfg.level(), options...)
+}
+
+// readConfigFile decodes the Config in the file at path.
+func readConfigFile(path string) (Config, error) {
+	return readConfigFS(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+}
+
+// readConfigFS decodes the Config in the file name of fsys.
+func readConfigFS(fsys fs.FS, name string) (Config, error) {
+	var cfg Config
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return cfg, err
+	}
+	if strings.EqualFold(path.Ext(name), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	} else {
+		err = decodeYAMLConfig(data, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("gzip: config file %s: %w", name, err)
+	}
+	return cfg, nil
+}
+
+// WatchConfigFile reloads h from the config file at path, see
+// NewFromConfigFile, whenever its modification time or size changes. It
+// checks every interval until ctx is done. A reload replaces the whole
+// configuration, including changes made by Reconfigure. A file that fails to
+// load leaves the configuration as it was, and the error is passed to
+// onError when it is not nil.
+func (h *Handler) WatchConfigFile(ctx context.Context, path string, interval time.Duration, onError func(error)) {
+	last, _ := os.Stat(path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err == nil && last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			if err == nil {
+				last = info
+				err = h.reload(path)
+			}
+			if err != nil {
+				if onError != nil {
+					onError(err)
+				} else {
+					debugPrintWARNING("reloading %s: %v", path, err)
+				}
+			}
+		}
+	}()
+}
+
+// reload replaces the configuration of h with the one in the file at path.
+func (h *Handler) reload(path string) error {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	options, err := cfg.options()
+	if err != nil {
+		return err
+	}
+	return h.reset(cfg.level(), options...)
+}
@@ -0,0 +1,20 @@
+//go:build !gzipminimal
+
+package gzip
+
+import (
+	"bytes"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeYAMLConfig decodes data, a YAML config file, into cfg.
+func decodeYAMLConfig(data []byte, cfg *Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		return err
+	}
+	// an empty file is the default configuration
+	return nil
+}
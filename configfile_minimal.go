@@ -0,0 +1,11 @@
+//go:build gzipminimal
+
+package gzip
+
+import "errors"
+
+// decodeYAMLConfig reports any YAML config file: gzipminimal builds only read
+// JSON ones.
+func decodeYAMLConfig([]byte, *Config) error {
+	return errors.New("YAML config files are not available in gzipminimal builds")
+}
@@ -0,0 +1,77 @@
+package gzip
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EncodingSourceKey is the gin context key under which the middleware records
+// where the final Content-Encoding of a response came from.
+const EncodingSourceKey = "gzip.encoding_source"
+
+// Values stored under EncodingSourceKey.
+const (
+	// EncodingSourceLocal means the middleware compressed the response.
+	EncodingSourceLocal = "local"
+	// EncodingSourceUpstream means the handler, typically a reverse proxy,
+	// produced an encoded body that was passed through.
+	EncodingSourceUpstream = "upstream"
+)
+
+// EncodingKey is the gin context key under which the middleware records the
+// content coding of the response, e.g. "gzip", for logging and metrics
+// middleware. It is set once the middleware decides to compress, before the
+// body is written, and for encoded responses passed through once the
+// middleware has finished. Identity responses leave it unset.
+const EncodingKey = "gzip.encoding"
+
+// DecisionDurationKey is the gin context key under which the middleware
+// records, as a time.Duration, how long the response pipeline took on the
+// first write. See WithDecisionBudget.
+const DecisionDurationKey = "gzip.decision_duration"
+
+// SkipKey is the gin context key that, set to true before the first byte of
+// the body is written, leaves the current response uncompressed.
+const SkipKey = "gzip.skip"
+
+// Disable opts the current response out of compression. It must be called
+// before the handler writes the body, e.g. when a route sometimes streams
+// binary blobs.
+func Disable(c *gin.Context) {
+	c.Set(SkipKey, true)
+}
+
+// EncodingSourceFromContext returns EncodingSourceLocal, EncodingSourceUpstream
+// or "" for identity responses, once the middleware has finished.
+func EncodingSourceFromContext(c *gin.Context) string {
+	return c.GetString(EncodingSourceKey)
+}
+
+// EncodingFromContext returns the content coding recorded under EncodingKey,
+// or "" for identity responses.
+func EncodingFromContext(c *gin.Context) string {
+	return c.GetString(EncodingKey)
+}
+
+func recordEncodingSource(c *gin.Context, compressed bool) {
+	switch {
+	case compressed:
+		c.Set(EncodingSourceKey, EncodingSourceLocal)
+		c.Set(EncodingKey, "gzip")
+	case hasContentEncoding(c.Writer.Header()):
+		c.Set(EncodingSourceKey, EncodingSourceUpstream)
+		c.Set(EncodingKey, strings.ToLower(strings.TrimSpace(c.Writer.Header().Get("Content-Encoding"))))
+	default:
+		if _, ok := c.Get(EncodingKey); ok {
+			// decided to compress, but no body was written after all
+			c.Set(EncodingKey, "")
+		}
+	}
+}
+
+func hasContentEncoding(header http.Header) bool {
+	v := header.Get("Content-Encoding")
+	return v != "" && v != "identity"
+}
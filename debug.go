@@ -0,0 +1,62 @@
+package gzip
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// debugPrintWARNING mirrors gin's own debug output so middleware warnings
+// only show up when gin runs in debug mode.
+func debugPrintWARNING(format string, values ...interface{}) {
+	if !gin.IsDebugging() {
+		return
+	}
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	fmt.Fprintf(gin.DefaultWriter, "[GIN-debug] [WARNING] [GZIP] "+format, values...)
+}
+
+// conflictingMiddlewares are handler name fragments of middlewares that
+// rewrite or cache the response body, so they must run before gzip.Gzip.
+var conflictingMiddlewares = []string{"gzip", "brotli", "deflate", "zstd", "compress", "cache"}
+
+// checkedRoutes remembers the routes whose chain was already checked.
+var checkedRoutes sync.Map
+
+// checkOrder warns, once per route and only in debug mode, about middlewares
+// registered after gzip that would see or produce compressed bodies.
+func checkOrder(c *gin.Context) {
+	if !gin.IsDebugging() {
+		return
+	}
+	if _, checked := checkedRoutes.LoadOrStore(c.FullPath(), struct{}{}); checked {
+		return
+	}
+	names := c.HandlerNames()
+	self := -1
+	for i, name := range names {
+		if strings.HasSuffix(name, "(*gzipHandler).Handle-fm") || strings.HasSuffix(name, "(*Handler).Handle-fm") {
+			self = i
+			break
+		}
+	}
+	if self < 0 || self >= len(names)-1 {
+		// not found, or nothing registered after it, e.g. on NoRoute
+		return
+	}
+	// the last handler serves the route, only the middlewares in between matter
+	for _, name := range names[self+1 : len(names)-1] {
+		lower := strings.ToLower(name)
+		for _, fragment := range conflictingMiddlewares {
+			if strings.Contains(lower, fragment) {
+				debugPrintWARNING("%s is registered after gzip.Gzip on %s, "+
+					"register it before gzip.Gzip so it handles uncompressed bodies", name, c.FullPath())
+				break
+			}
+		}
+	}
+}
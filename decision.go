@@ -0,0 +1,348 @@
+package gzip
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether a response is compressed is decided by two ordered pipelines of
+// stages. Every stage may veto compression; the first veto wins, its reason
+// is reported and the remaining stages are skipped.
+//
+// The request pipeline runs in Handle, before a gzip writer is taken from
+// the pool, and only sees the request:
+//
+//  1. disabled         SkipKey was set by an earlier handler, see Disable
+//  2. accept-encoding  the client does not accept gzip, see Options.AllowWildcardAccept
+//  3. upgrade          the request asks for a protocol upgrade, see Options.CompressUpgradeRequests
+//  4. event-stream     the client expects server-sent events
+//  5. http10           an HTTP/1.0 request, see Options.SkipHTTP10
+//  6. internal         the request comes from one of Options.SkipInternal
+//  7. method           Options.ExcludedMethods
+//  8. range            a byte range is requested, see Options.CompressRangeRequests
+//  9. extension        Options.ExcludedExtensions
+// 10. path             Options.ExcludedPaths
+// 11. path-regex       Options.ExcludedPathesRegexs
+// 12. path-matcher     Options.ExcludedPathMatcher
+// 13. route            Options.ExcludedRoutes
+// 14. query            Options.ExcludedQueryParams
+// 15. included-path    the path is in neither Options.IncludedPaths nor Options.IncludedPathsRegexs
+// 16. request-header   Options.RequestHeaderRules
+// 17. custom           Options.CustomShouldCompressFn
+//
+// The response pipeline runs in gzipWriter on the first write, once the
+// handler has set the status code and headers:
+//
+//  1. disabled           the handler called Disable before writing
+//  2. status             Options.ExcludedStatusCodes
+//  3. transfer-encoding  the handler sent a gzip transfer coding, moved to Content-Encoding
+//  4. content-encoding   the handler already encoded the body itself
+//  5. rewriter           Options.ResponseRewriter, which may also force compression
+//  6. min-length         the body is shorter than Options.MinLength
+//  7. content-type       Options.ExcludedContentTypes and Options.IncludedContentTypes
+//  8. sniff              the body starts like a compressed format, see Options.ContentSniffing
+//  9. range              206 Partial Content, see Options.CompressRangeRequests
+// 10. custom             Options.ResponseShouldCompressFn
+//
+// New built-in checks are added as stages at the appropriate position;
+// user-supplied policies plug in through the custom stages.
+
+// Reasons reported in Event.Reason, one per pipeline stage.
+const (
+	ReasonDisabled         = "disabled"
+	ReasonAcceptEncoding   = "accept-encoding"
+	ReasonUpgrade          = "upgrade"
+	ReasonEventStream      = "event-stream"
+	ReasonHTTP10           = "http10"
+	ReasonInternal         = "internal"
+	ReasonMethod           = "method"
+	ReasonRange            = "range"
+	ReasonExtension        = "extension"
+	ReasonPath             = "path"
+	ReasonPathRegex        = "path-regex"
+	ReasonPathMatcher      = "path-matcher"
+	ReasonRoute            = "route"
+	ReasonQuery            = "query"
+	ReasonIncludedPath     = "included-path"
+	ReasonRequestHeader    = "request-header"
+	ReasonCustom           = "custom"
+	ReasonStatus           = "status"
+	ReasonTransferEncoding = "transfer-encoding"
+	ReasonContentEncoding  = "content-encoding"
+	ReasonRewriter         = "rewriter"
+	ReasonMinLength        = "min-length"
+	ReasonContentType      = "content-type"
+	ReasonSniff            = "sniff"
+
+	// ReasonConcurrency is reported when MaxConcurrentCompressions responses
+	// are already being compressed.
+	ReasonConcurrency = "concurrency"
+)
+
+type requestStage struct {
+	reason string
+	skip   func(g *gzipHandler, c *gin.Context) bool
+}
+
+var requestStages = []requestStage{
+	{ReasonDisabled, func(_ *gzipHandler, c *gin.Context) bool {
+		return c.GetBool(SkipKey)
+	}},
+	{ReasonAcceptEncoding, func(g *gzipHandler, c *gin.Context) bool {
+		accept := cachedAcceptEncoding(c.Request.Header.Values("Accept-Encoding"))
+		return !accept.allowsGzip(g.AllowWildcardAccept)
+	}},
+	{ReasonUpgrade, func(g *gzipHandler, c *gin.Context) bool {
+		return !g.CompressUpgradeRequests && isUpgradeRequest(c.Request.Header)
+	}},
+	{ReasonEventStream, func(_ *gzipHandler, c *gin.Context) bool {
+		return strings.Contains(c.Request.Header.Get("Accept"), "text/event-stream")
+	}},
+	{ReasonHTTP10, func(g *gzipHandler, c *gin.Context) bool {
+		return g.SkipHTTP10 && !c.Request.ProtoAtLeast(1, 1)
+	}},
+	{ReasonInternal, func(g *gzipHandler, c *gin.Context) bool {
+		return fromNetworks(g.internal, c)
+	}},
+	{ReasonMethod, func(g *gzipHandler, c *gin.Context) bool {
+		return g.ExcludedMethods.Contains(c.Request.Method)
+	}},
+	{ReasonRange, func(g *gzipHandler, c *gin.Context) bool {
+		return !g.CompressRangeRequests && c.Request.Header.Get("Range") != ""
+	}},
+	{ReasonExtension, func(g *gzipHandler, c *gin.Context) bool {
+		return g.ExcludedExtensions.Contains(filepath.Ext(g.matchPath(c)))
+	}},
+	{ReasonPath, func(g *gzipHandler, c *gin.Context) bool {
+		return g.ExcludedPaths.Contains(g.matchPath(c))
+	}},
+	{ReasonPathRegex, func(g *gzipHandler, c *gin.Context) bool {
+		return g.ExcludedPathesRegexs.Contains(g.matchPath(c))
+	}},
+	{ReasonPathMatcher, func(g *gzipHandler, c *gin.Context) bool {
+		return g.ExcludedPathMatcher != nil && g.ExcludedPathMatcher.Contains(c.Request.URL.Path)
+	}},
+	{ReasonRoute, func(g *gzipHandler, c *gin.Context) bool {
+		return g.ExcludedRoutes.Contains(c.FullPath())
+	}},
+	{ReasonQuery, func(g *gzipHandler, c *gin.Context) bool {
+		return len(g.ExcludedQueryParams) > 0 && g.ExcludedQueryParams.Contains(c.Request.URL.Query())
+	}},
+	{ReasonIncludedPath, func(g *gzipHandler, c *gin.Context) bool {
+		if g.IncludedPaths == nil && g.IncludedPathsRegexs == nil {
+			return false
+		}
+		path := g.matchPath(c)
+		return !g.IncludedPaths.Contains(path) && !g.IncludedPathsRegexs.Contains(path)
+	}},
+	{ReasonRequestHeader, func(g *gzipHandler, c *gin.Context) bool {
+		return matchHeaderRules(g.headerRules, c.Request.Header)
+	}},
+	{ReasonCustom, func(g *gzipHandler, c *gin.Context) bool {
+		return g.CustomShouldCompressFn != nil && !g.CustomShouldCompressFn(c)
+	}},
+}
+
+// matchPath returns the request path the path stages match, lowercased with
+// Options.CaseInsensitiveMatching.
+func (g *gzipHandler) matchPath(c *gin.Context) string {
+	if g.CaseInsensitiveMatching {
+		return strings.ToLower(c.Request.URL.Path)
+	}
+	return c.Request.URL.Path
+}
+
+// compressedSignatures are the leading bytes of formats that do not compress
+// any further.
+var compressedSignatures = [][]byte{
+	[]byte("\x89PNG\r\n\x1a\n"), // PNG
+	[]byte("\xff\xd8\xff"),      // JPEG
+	[]byte("PK\x03\x04"),        // ZIP and formats built on it
+	[]byte("%PDF-"),             // PDF
+	gzipMagic,                   // gzip
+}
+
+// isCompressedFormat reports whether head starts with the signature of an
+// already compressed format.
+func isCompressedFormat(head []byte) bool {
+	for _, sig := range compressedSignatures {
+		if bytes.HasPrefix(head, sig) {
+			return true
+		}
+	}
+	// MP4 and other ISO base media files carry "ftyp" after the box size
+	return len(head) >= 8 && string(head[4:8]) == "ftyp"
+}
+
+// isUpgradeRequest reports whether the request asks to switch protocols,
+// either through an "upgrade" token in Connection or an Upgrade header.
+func isUpgradeRequest(header http.Header) bool {
+	return headerHasToken(header, "Connection", "upgrade") || header.Get("Upgrade") != ""
+}
+
+// headerHasToken reports whether the comma-separated list in header key holds
+// token, compared case-insensitively as RFC 7230 requires.
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for rest, more := value, true; more; {
+			var t string
+			t, rest, more = strings.Cut(rest, ",")
+			if strings.EqualFold(strings.Trim(t, " \t"), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestSkipReason runs the request pipeline and returns the reason of the
+// first stage vetoing compression, or "" when the response may be compressed.
+func (g *gzipHandler) requestSkipReason(c *gin.Context) string {
+	for _, stage := range requestStages {
+		if stage.skip(g, c) {
+			return stage.reason
+		}
+	}
+	return ""
+}
+
+type responseStage struct {
+	reason string
+	skip   func(g *gzipHandler, w *gzipWriter) bool
+}
+
+var responseStages = []responseStage{
+	{ReasonDisabled, func(_ *gzipHandler, w *gzipWriter) bool {
+		return w.ctx.GetBool(SkipKey)
+	}},
+	{ReasonStatus, func(g *gzipHandler, w *gzipWriter) bool {
+		return g.ExcludedStatusCodes.Contains(w.Status())
+	}},
+	{ReasonTransferEncoding, func(_ *gzipHandler, w *gzipWriter) bool {
+		return moveTransferCoding(w.Header())
+	}},
+	{ReasonContentEncoding, func(_ *gzipHandler, w *gzipWriter) bool {
+		values := w.Header().Values("Content-Encoding")
+		if len(values) > 1 || (len(values) == 1 && strings.Contains(values[0], ",")) {
+			// The handler stacked its own codings; never add another layer.
+			debugPrintWARNING("multiple Content-Encoding values %q set by handler, "+
+				"response is passed through uncompressed", values)
+		}
+		return hasContentEncoding(w.Header())
+	}},
+	{ReasonRewriter, func(g *gzipHandler, w *gzipWriter) bool {
+		if g.ResponseRewriter == nil {
+			return false
+		}
+		d := g.ResponseRewriter(w.Header(), w.head)
+		w.forced = d == DecisionCompress
+		return d == DecisionSkip
+	}},
+	{ReasonMinLength, func(g *gzipHandler, w *gzipWriter) bool {
+		n := w.knownLength()
+		return n >= 0 && n < g.MinLength
+	}},
+	{ReasonContentType, func(g *gzipHandler, w *gzipWriter) bool {
+		contentType := w.Header().Get("Content-Type")
+		return g.ExcludedContentTypes.Contains(contentType) ||
+			(g.IncludedContentTypes != nil && !g.IncludedContentTypes.Contains(contentType))
+	}},
+	{ReasonSniff, func(g *gzipHandler, w *gzipWriter) bool {
+		return g.ContentSniffing && isCompressedFormat(w.head)
+	}},
+	{ReasonRange, func(g *gzipHandler, w *gzipWriter) bool {
+		// compressing a partial response would break its byte offsets
+		return !g.CompressRangeRequests && w.Status() == http.StatusPartialContent
+	}},
+	{ReasonCustom, func(g *gzipHandler, w *gzipWriter) bool {
+		return g.ResponseShouldCompressFn != nil && !g.responseShouldCompress(w.Status(), w.Header())
+	}},
+}
+
+// moveTransferCoding rewrites a gzip coding in the Transfer-Encoding set by a
+// handler, typically a proxied legacy application, into Content-Encoding so
+// the response never carries both. Strict intermediaries reject that, and
+// net/http only frames chunked itself. It reports whether it did.
+func moveTransferCoding(header http.Header) bool {
+	if !headerHasToken(header, "Transfer-Encoding", "gzip") &&
+		!headerHasToken(header, "Transfer-Encoding", "x-gzip") {
+		return false
+	}
+	removeHeaderValue(header, "Transfer-Encoding", "gzip")
+	removeHeaderValue(header, "Transfer-Encoding", "x-gzip")
+	// the transfer coding was applied last, after any content coding
+	if v := header.Get("Content-Encoding"); hasContentEncoding(header) {
+		header.Set("Content-Encoding", v+", gzip")
+	} else {
+		header.Set("Content-Encoding", "gzip")
+	}
+	header.Del("Content-Length")
+	return true
+}
+
+// responseShouldCompress calls ResponseShouldCompressFn, within
+// DecisionBudget when one is set. A panic inside the budget is re-raised on
+// the handler goroutine.
+func (g *gzipHandler) responseShouldCompress(status int, header http.Header) bool {
+	if g.DecisionBudget <= 0 {
+		return g.ResponseShouldCompressFn(status, header)
+	}
+	type result struct {
+		compress bool
+		panicked interface{}
+	}
+	done := make(chan result, 1)
+	header = header.Clone()
+	go func() {
+		var r result
+		defer func() {
+			r.panicked = recover()
+			done <- r
+		}()
+		r.compress = g.ResponseShouldCompressFn(status, header)
+	}()
+
+	timer := time.NewTimer(g.DecisionBudget)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.panicked != nil {
+			panic(r.panicked)
+		}
+		return r.compress
+	case <-timer.C:
+		debugPrintWARNING("ResponseShouldCompressFn exceeded the decision budget of %s, compressing", g.DecisionBudget)
+		return true
+	}
+}
+
+// responseSkipReason runs the response pipeline, see requestSkipReason.
+func (g *gzipHandler) responseSkipReason(w *gzipWriter) string {
+	for _, stage := range responseStages {
+		if stage.skip(g, w) {
+			return stage.reason
+		}
+		if w.forced {
+			return ""
+		}
+	}
+	return ""
+}
+
+// Decision is the verdict of a ResponseRewriter.
+type Decision int
+
+const (
+	// DecisionDefault leaves the decision to the stages after the rewriter.
+	DecisionDefault Decision = iota
+	// DecisionSkip serves the response uncompressed.
+	DecisionSkip
+	// DecisionCompress compresses the response, skipping the stages after
+	// the rewriter.
+	DecisionCompress
+)
@@ -0,0 +1,250 @@
+package gzip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrMultistream is returned when reading a request body that holds more than
+// one gzip member while WithRejectMultistream is set.
+var ErrMultistream = errors.New("gzip: multiple members in request body")
+
+// gzipMagic starts every gzip member.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ErrInvalidContentEncoding is returned for request Content-Encoding headers
+// that cannot be parsed unambiguously.
+var ErrInvalidContentEncoding = errors.New("gzip: invalid request Content-Encoding")
+
+// ErrDecompressionRatio is returned when reading a request body that expands
+// beyond WithMaxDecompressionRatio.
+var ErrDecompressionRatio = errors.New("gzip: request body decompression ratio exceeded")
+
+// ratioGrace is the decompressed size below which the ratio is not enforced,
+// since small bodies of repetitive data legitimately compress very well.
+const ratioGrace = 64 << 10
+
+// optionsKey stores the options of the running middleware on the gin context
+// so DecompressFn implementations such as DefaultDecompressHandle can use them.
+const optionsKey = "github.com/gin-contrib/gzip/options"
+
+// contextOptions returns the options stored by the running middleware, or
+// DefaultOptions when called outside of it.
+func contextOptions(c *gin.Context) *Options {
+	if v, ok := c.Get(optionsKey); ok {
+		if o, ok := v.(*Options); ok {
+			return o
+		}
+	}
+	return DefaultOptions
+}
+
+func DefaultDecompressHandle(c *gin.Context) {
+	if c.Request.Body == nil {
+		return
+	}
+	opts := contextOptions(c)
+
+	compressed := &countingReader{r: c.Request.Body}
+	head := &headRecorder{r: compressed}
+	src := bufio.NewReader(head)
+	if opts.LenientDecompression {
+		if magic, _ := src.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{src, c.Request.Body}
+			c.Request.Header.Del("Content-Encoding")
+			return
+		}
+	}
+	r, err := newDecompressor(src)
+	if err != nil {
+		// hand the body back unread in case the error handler carries on
+		c.Request.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head.data), c.Request.Body), c.Request.Body}
+		opts.decompressError(c, err)
+		return
+	}
+	head.stop()
+
+	var body io.ReadCloser = r
+	if opts.RejectMultistream {
+		r.Multistream(false)
+		body = &singleMemberReader{decompressor: r, src: src}
+	}
+	if opts.MaxDecompressedSize > 0 {
+		body = http.MaxBytesReader(c.Writer, body, opts.MaxDecompressedSize)
+	}
+	if opts.EventHook != nil {
+		body = &failureReporter{ReadCloser: body, report: func(err error) {
+			opts.emit(c, Event{Kind: EventDecompressFailed, Err: err})
+		}}
+	}
+	if opts.MaxDecompressionRatio > 0 {
+		body = &ratioReader{ReadCloser: body, compressed: compressed, ratio: opts.MaxDecompressionRatio,
+			exceeded: func() { opts.decompressError(c, ErrDecompressionRatio) }}
+	}
+	c.Request = decompressedRequest(c.Request, body)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// ratioReader fails reads once the decompressed body outgrows ratio times
+// the compressed bytes read, calling exceeded the first time.
+type ratioReader struct {
+	io.ReadCloser
+	compressed *countingReader
+	ratio      float64
+	n          int64
+	exceeded   func()
+	failed     bool
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	if r.failed {
+		return 0, ErrDecompressionRatio
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if r.n > ratioGrace && float64(r.n) > r.ratio*float64(r.compressed.n) {
+		r.failed = true
+		r.exceeded()
+		return 0, ErrDecompressionRatio
+	}
+	return n, err
+}
+
+// decompressError reports a request body that cannot be decompressed, with a
+// 400 Bad Request unless DecompressErrorHandler is set.
+func (o *Options) decompressError(c *gin.Context, err error) {
+	o.emit(c, Event{Kind: EventDecompressFailed, Err: err})
+	if o.DecompressErrorHandler != nil {
+		o.DecompressErrorHandler(c, err)
+		return
+	}
+	_ = c.AbortWithError(http.StatusBadRequest, err)
+}
+
+// headRecorder keeps the bytes read through it until stop is called, so a
+// body whose gzip header turns out to be invalid can be replayed.
+type headRecorder struct {
+	r       io.Reader
+	data    []byte
+	stopped bool
+}
+
+func (h *headRecorder) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if !h.stopped {
+		h.data = append(h.data, p[:n]...)
+	}
+	return n, err
+}
+
+func (h *headRecorder) stop() {
+	h.stopped = true
+	h.data = nil
+}
+
+// requestGzipEncoded reports whether the request body is encoded with gzip
+// and nothing else. The header is parsed as a list of codings the way servers
+// upstream read it: optional whitespace is trimmed, empty list elements are
+// ignored and names are case-insensitive, so "GZIP ," means gzip. Values
+// holding control characters or whitespace inside a coding are rejected
+// instead of guessed at.
+//
+// A gzip transfer coding counts as well. net/http answers such requests with
+// 501 Not Implemented itself, but requests built by adapters, e.g. for
+// serverless platforms, reach the middleware with it.
+func requestGzipEncoded(req *http.Request) (bool, error) {
+	codings, err := parseCodings(req.Header.Values("Content-Encoding"))
+	if err != nil {
+		return false, err
+	}
+	transferEncoding := req.TransferEncoding
+	if len(transferEncoding) == 0 {
+		transferEncoding = req.Header.Values("Transfer-Encoding")
+	}
+	transferCodings, err := parseCodings(transferEncoding)
+	if err != nil {
+		return false, err
+	}
+	for _, coding := range transferCodings {
+		if coding != "chunked" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	return len(codings) == 1 && (codings[0] == "gzip" || codings[0] == "x-gzip"), nil
+}
+
+// parseCodings returns the lowercased codings listed in header values.
+func parseCodings(values []string) ([]string, error) {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.Trim(coding, " \t")
+			if strings.IndexFunc(coding, isInvalidCodingRune) >= 0 {
+				return nil, ErrInvalidContentEncoding
+			}
+			if coding != "" {
+				codings = append(codings, strings.ToLower(coding))
+			}
+		}
+	}
+	return codings, nil
+}
+
+func isInvalidCodingRune(r rune) bool {
+	return r <= ' ' || r == 0x7f
+}
+
+// decompressedRequest returns a copy of req reading from the decoded body, with
+// framing fields describing a body of unknown length so that binding,
+// multipart parsing and reverse proxies all see a consistent request.
+func decompressedRequest(req *http.Request, body io.ReadCloser) *http.Request {
+	r := req.Clone(req.Context())
+	r.Body = body
+	r.GetBody = nil
+	r.ContentLength = -1
+	r.TransferEncoding = []string{"chunked"}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.Header.Del("Transfer-Encoding")
+	return r
+}
+
+// singleMemberReader reads the first gzip member and fails if anything
+// follows it.
+type singleMemberReader struct {
+	*decompressor
+	src *bufio.Reader
+}
+
+func (r *singleMemberReader) Read(p []byte) (int, error) {
+	n, err := r.decompressor.Read(p)
+	if errors.Is(err, io.EOF) {
+		if _, peekErr := r.src.Peek(1); peekErr == nil {
+			return n, ErrMultistream
+		}
+	}
+	return n, err
+}
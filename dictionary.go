@@ -0,0 +1,62 @@
+package gzip
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dictionary is one of Options.Dictionaries with its SHA-256, by which
+// clients name it in Available-Dictionary.
+type dictionary struct {
+	data []byte
+	hash [sha256.Size]byte
+}
+
+// newDictionaries returns a writer pool at level for each of dicts, by hash.
+func newDictionaries(dicts [][]byte, level int, cfg PoolConfig) (map[[sha256.Size]byte]*writerPool, error) {
+	if len(dicts) == 0 {
+		return nil, nil
+	}
+	if !dictionaryCompression {
+		return nil, errors.New("gzip: dictionaries are only available in builds with -tags klauspost")
+	}
+	pools := make(map[[sha256.Size]byte]*writerPool, len(dicts))
+	for _, data := range dicts {
+		d := &dictionary{data: data, hash: sha256.Sum256(data)}
+		pools[d.hash] = newDictionaryPool(level, cfg, d)
+	}
+	return pools, nil
+}
+
+// dictionaryPool returns the pool of the dictionary named by the
+// Available-Dictionary header of a request accepting dcz, or nil.
+func (g *gzipHandler) dictionaryPool(c *gin.Context) *writerPool {
+	if g.dictionaries == nil {
+		return nil
+	}
+	hash, ok := parseAvailableDictionary(c.Request.Header.Get("Available-Dictionary"))
+	if !ok || !cachedAcceptEncoding(c.Request.Header.Values("Accept-Encoding")).allowsDictionary() {
+		return nil
+	}
+	return g.dictionaries[hash]
+}
+
+// parseAvailableDictionary decodes the SHA-256 an Available-Dictionary header
+// carries as a structured field byte sequence, e.g. ":<base64>:".
+func parseAvailableDictionary(value string) ([sha256.Size]byte, bool) {
+	var hash [sha256.Size]byte
+	value = strings.Trim(value, " \t")
+	if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+		return hash, false
+	}
+	data, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+	if err != nil || len(data) != len(hash) {
+		return hash, false
+	}
+	copy(hash[:], data)
+	return hash, true
+}
@@ -0,0 +1,93 @@
+//go:build klauspost
+
+package gzip
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// dictionaryCompression reports whether Options.Dictionaries can be served,
+// which takes the zstd encoder of github.com/klauspost/compress.
+const dictionaryCompression = true
+
+// dczMagic starts the header of dcz responses, a zstd skippable frame holding
+// the SHA-256 of the dictionary.
+var dczMagic = [...]byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+
+// dczWindowSize is the largest zstd window dcz allows whatever the size of
+// the dictionary.
+const dczWindowSize = 8 << 20
+
+func (d *dictionary) newEncoder(level int) encoder {
+	zw, err := zstd.NewWriter(nil,
+		zstd.WithEncoderDictRaw(0, d.data),
+		zstd.WithEncoderLevel(zstdLevel(level)),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(dczWindowSize),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return &dczEncoder{zw: zw, header: append(append([]byte(nil), dczMagic[:]...), d.hash[:]...)}
+}
+
+// zstdLevel maps a gzip level to the closest zstd encoder level.
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level == BestCompression:
+		return zstd.SpeedBestCompression
+	case level >= 6:
+		return zstd.SpeedBetterCompression
+	case level == DefaultCompression || level > BestSpeed:
+		return zstd.SpeedDefault
+	default:
+		return zstd.SpeedFastest
+	}
+}
+
+// dczEncoder writes a dcz stream: the header naming the dictionary, then a
+// zstd frame compressed with it.
+type dczEncoder struct {
+	zw     *zstd.Encoder
+	header []byte
+	dst    io.Writer
+	// started is set once the header has been written to dst
+	started bool
+}
+
+func (e *dczEncoder) Reset(w io.Writer) {
+	e.dst, e.started = w, false
+	e.zw.Reset(w)
+}
+
+func (e *dczEncoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	_, err := e.dst.Write(e.header)
+	return err
+}
+
+func (e *dczEncoder) Write(p []byte) (int, error) {
+	if err := e.start(); err != nil {
+		return 0, err
+	}
+	return e.zw.Write(p)
+}
+
+func (e *dczEncoder) Flush() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.zw.Flush()
+}
+
+func (e *dczEncoder) Close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
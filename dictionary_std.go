@@ -0,0 +1,12 @@
+//go:build !klauspost
+
+package gzip
+
+// dictionaryCompression reports whether Options.Dictionaries can be served;
+// build with -tags klauspost to set it.
+const dictionaryCompression = false
+
+// newEncoder is never called: New rejects Options.Dictionaries.
+func (d *dictionary) newEncoder(int) encoder {
+	panic("gzip: dictionaries are only available in builds with -tags klauspost")
+}
@@ -0,0 +1,54 @@
+package gzip
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventKind tells what an Event reports.
+type EventKind int
+
+const (
+	// EventSkipped is reported when a response is left uncompressed.
+	EventSkipped EventKind = iota + 1
+	// EventDecompressFailed is reported when a gzip request body is rejected
+	// or fails to decode.
+	EventDecompressFailed
+)
+
+// Event is passed to the hook set with WithEventHook.
+type Event struct {
+	Kind EventKind
+	// Reason is one of the Reason constants for EventSkipped.
+	Reason string
+	// Err is the decoding error for EventDecompressFailed.
+	Err error
+}
+
+func (o *Options) emit(c *gin.Context, e Event) {
+	if o.Stats {
+		recordEvent(e)
+	}
+	if o.EventHook != nil {
+		o.EventHook(c, e)
+	}
+}
+
+// failureReporter reports the first error other than io.EOF returned while
+// reading a decompressed request body.
+type failureReporter struct {
+	io.ReadCloser
+	once   sync.Once
+	report func(err error)
+}
+
+func (r *failureReporter) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.once.Do(func() { r.report(err) })
+	}
+	return n, err
+}
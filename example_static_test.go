@@ -0,0 +1,62 @@
+//go:build !gzipminimal
+
+package gzip_test
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing/fstest"
+
+	"github.com/gin-contrib/gzip"
+	"github.com/gin-gonic/gin"
+)
+
+func ExampleStaticFS() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	// typically an embed.FS; .gz siblings written by gzip-precompress are served
+	// as they are, other files are compressed on the fly
+	assets := fstest.MapFS{
+		"app.js":      {Data: []byte(strings.Repeat("console.log(1);\n", 100))},
+		"favicon.ico": {Data: []byte("icon")},
+	}
+	r := gin.New()
+	r.GET("/assets/*filepath", gzip.StaticFS(assets))
+
+	for _, path := range []string{"/assets/app.js", "/assets/favicon.ico"} {
+		res, _ := get(r, path)
+		fmt.Printf("%s: %q %s\n", path, res.Header.Get("Content-Encoding"), res.Header.Get("Cache-Control"))
+	}
+	// Output:
+	// /assets/app.js: "gzip" public, max-age=31536000, immutable
+	// /assets/favicon.ico: "" public, max-age=31536000, immutable
+}
+
+func ExampleStaticSiteModeFS() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	var buf bytes.Buffer
+	gw := stdgzip.NewWriter(&buf)
+	_, _ = gw.Write([]byte("precompressed bundle"))
+	_ = gw.Close()
+	// typically an embed.FS or os.DirFS of the build output
+	site := fstest.MapFS{"app.js.gz": {Data: buf.Bytes()}}
+
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.StaticSiteModeFS(site)))
+	// siblings are only looked up for requests no route matches
+	r.NoRoute(func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat("<p>rendered</p>", 100))
+	})
+
+	_, body := get(r, "/app.js")
+	fmt.Println(body)
+	res, _ := get(r, "/index.html")
+	fmt.Println(res.Header.Get("Content-Encoding"))
+	// Output:
+	// precompressed bundle
+	// gzip
+}
@@ -0,0 +1,300 @@
+package gzip_test
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/gzip"
+	"github.com/gin-gonic/gin"
+)
+
+// get serves router on a local server, sends it a request accepting gzip and
+// returns the response with its body decompressed.
+func get(router http.Handler, target string) (*http.Response, string) {
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+target, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := server.Client().Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err.Error()
+	}
+	defer res.Body.Close()
+
+	var r io.Reader = res.Body
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		gr, err := stdgzip.NewReader(res.Body)
+		if err != nil {
+			return res, err.Error()
+		}
+		r = gr
+	}
+	data, _ := io.ReadAll(r)
+	return res, string(data)
+}
+
+func ExampleGzip() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/metrics"})))
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+	r.GET("/metrics", func(c *gin.Context) {
+		c.String(http.StatusOK, "requests 1")
+	})
+
+	for _, path := range []string{"/ping", "/metrics"} {
+		res, body := get(r, path)
+		fmt.Printf("%s: %q %s\n", path, res.Header.Get("Content-Encoding"), body)
+	}
+	// Output:
+	// /ping: "gzip" pong
+	// /metrics: "" requests 1
+}
+
+func ExampleDefaultDecompressHandle() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression,
+		gzip.WithDecompressFn(gzip.DefaultDecompressHandle),
+		gzip.WithRejectMultistream(),
+	))
+	r.POST("/upload", func(c *gin.Context) {
+		data, err := c.GetRawData()
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, "received %q", data)
+	})
+
+	var buf bytes.Buffer
+	gw := stdgzip.NewWriter(&buf)
+	_, _ = gw.Write([]byte("hello"))
+	_ = gw.Close()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/upload", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	fmt.Println(w.Body.String())
+	// Output: received "hello"
+}
+
+func ExampleStreamJSONPages() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	r.GET("/items", func(c *gin.Context) {
+		page := 0
+		_ = gzip.StreamJSONPages(c, http.StatusOK, func() (interface{}, bool) {
+			page++
+			return gin.H{"page": page}, page <= 2
+		}, gin.H{"pages": 2})
+	})
+
+	res, body := get(r, "/items")
+	fmt.Println(res.Header.Get("Content-Type"))
+	fmt.Print(body)
+	// Output:
+	// application/x-ndjson
+	// {"page":1}
+	// {"page":2}
+	// {"pages":2}
+}
+
+func ExampleStreamer() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	r.GET("/events", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		n := 0
+		c.Stream(func(io.Writer) bool {
+			n++
+			fmt.Fprintf(gzip.Streamer(c), "data: %d\n\n", n)
+			return n < 2
+		})
+	})
+
+	_, body := get(r, "/events")
+	fmt.Print(body)
+	// Output:
+	// data: 1
+	//
+	// data: 2
+}
+
+func ExampleWrapDirector() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "upstream saw Accept-Encoding %q", req.Header.Get("Accept-Encoding"))
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Director = gzip.WrapDirector(proxy.Director)
+
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	r.Any("/*path", gin.WrapH(proxy))
+
+	res, body := get(r, "/")
+	fmt.Println(res.Header.Get("Content-Encoding"))
+	fmt.Println(body)
+	// Output:
+	// gzip
+	// upstream saw Accept-Encoding "identity"
+}
+
+func ExampleWithBuffering() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithBuffering(64<<10)))
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat("compressible ", 1000))
+	})
+	res, _ := get(r, "/")
+	fmt.Println(res.Header.Get("Content-Encoding"), res.ContentLength > 0, res.TransferEncoding)
+	// Output: gzip true []
+}
+
+func ExampleDisable() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	r.GET("/blob", func(c *gin.Context) {
+		if c.Query("raw") != "" {
+			gzip.Disable(c)
+		}
+		c.String(http.StatusOK, "blob")
+	})
+
+	for _, target := range []string{"/blob", "/blob?raw=1"} {
+		res, _ := get(r, target)
+		fmt.Printf("%s: %q\n", target, res.Header.Get("Content-Encoding"))
+	}
+	// Output:
+	// /blob: "gzip"
+	// /blob?raw=1: ""
+}
+
+func ExampleAPIMode() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.APIMode()))
+	r.GET("/users", func(c *gin.Context) {
+		users := make([]gin.H, 100)
+		for i := range users {
+			users[i] = gin.H{"id": i, "name": "user"}
+		}
+		c.JSON(http.StatusOK, users)
+	})
+	r.GET("/users/1", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"id": 1, "name": "user"})
+	})
+	r.GET("/avatar", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", bytes.Repeat([]byte("png"), 1000))
+	})
+
+	for _, path := range []string{"/users", "/users/1", "/avatar"} {
+		res, _ := get(r, path)
+		fmt.Printf("%s: %q\n", path, res.Header.Get("Content-Encoding"))
+	}
+	// Output:
+	// /users: "gzip"
+	// /users/1: ""
+	// /avatar: ""
+}
+
+func ExampleGatewayMode() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	// an upstream compressing whatever the client accepts
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := stdgzip.NewWriter(w)
+		_, _ = gw.Write([]byte("hello from upstream"))
+		_ = gw.Close()
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Director = gzip.WrapDirector(proxy.Director)
+
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.GatewayMode()))
+	r.Any("/*path", gin.WrapH(proxy))
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// a client that does not accept gzip, like curl without --compressed
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept-Encoding", "identity")
+	res, err := server.Client().Transport.RoundTrip(req)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	fmt.Printf("%q %s\n", res.Header.Get("Content-Encoding"), body)
+	// Output: "" hello from upstream
+}
+
+func ExampleWithMaxDecompressedSize() {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzip.Gzip(gzip.DefaultCompression,
+		gzip.WithDecompressFn(gzip.DefaultDecompressHandle),
+		gzip.WithMaxDecompressedSize(1<<10),
+	))
+	r.POST("/upload", func(c *gin.Context) {
+		data, err := c.GetRawData()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.String(http.StatusRequestEntityTooLarge, "over %d bytes", tooLarge.Limit)
+			return
+		}
+		c.String(http.StatusOK, "received %d bytes", len(data))
+	})
+
+	for _, size := range []int{100, 1 << 20} {
+		var buf bytes.Buffer
+		gw := stdgzip.NewWriter(&buf)
+		_, _ = gw.Write(bytes.Repeat([]byte("a"), size))
+		_ = gw.Close()
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "/upload", &buf)
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		fmt.Println(w.Code, w.Body)
+	}
+	// Output:
+	// 200 received 100 bytes
+	// 413 over 1024 bytes
+}
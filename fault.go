@@ -0,0 +1,43 @@
+package gzip
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInjectedFault is the default error returned by FaultInjection.
+var ErrInjectedFault = errors.New("gzip: injected fault")
+
+// FaultInjection makes the compressor misbehave in controlled ways so that
+// applications, and this package, can test their error handling. It is meant
+// for tests only; the zero value injects nothing.
+type FaultInjection struct {
+	// WriteErr, when set, fails writes once WriteErrAfter uncompressed bytes
+	// have been accepted.
+	WriteErr      error
+	WriteErrAfter int
+	// CloseErr is reported when the gzip stream is closed.
+	CloseErr error
+	// WriteDelay is slept before every write.
+	WriteDelay time.Duration
+}
+
+// WithFaultInjection injects the configured failures into every compressed
+// response. Close errors are recorded with c.Error.
+func WithFaultInjection(f FaultInjection) Option {
+	return func(o *Options) {
+		o.FaultInjection = &f
+	}
+}
+
+// limitWrite applies the configured delay and returns how many of the n
+// bytes about to be written may go through, with the error to report.
+func (f *FaultInjection) limitWrite(written, n int) (int, error) {
+	if f.WriteDelay > 0 {
+		time.Sleep(f.WriteDelay)
+	}
+	if f.WriteErr == nil || written+n <= f.WriteErrAfter {
+		return n, nil
+	}
+	return max(0, f.WriteErrAfter-written), f.WriteErr
+}
@@ -0,0 +1,100 @@
+package gzip
+
+import (
+	"time"
+)
+
+// FlushPolicy controls what Flush does to the gzip stream, see
+// WithFlushPolicy.
+type FlushPolicy struct {
+	buffered bool
+	interval time.Duration
+}
+
+var (
+	// FlushImmediate ends the current deflate block on every Flush so all data
+	// written so far reaches the client, which suits server-sent events and
+	// other latency sensitive streams. It is the default.
+	FlushImmediate = FlushPolicy{}
+	// FlushBuffered only flushes the underlying writer. Data still held by the
+	// compressor waits for the next block, which keeps the ratio of streams
+	// that flush often close to that of a single write.
+	FlushBuffered = FlushPolicy{buffered: true}
+)
+
+// FlushInterval flushes like FlushBuffered, and in addition ends the current
+// deflate block once d has passed since the last one ended, from a timer
+// started on the first write, so long streams make progress without flushing
+// on every write, even while the handler is waiting for more data.
+func FlushInterval(d time.Duration) FlushPolicy {
+	return FlushPolicy{buffered: true, interval: d}
+}
+
+// startTimer arms the timer ending deflate blocks for FlushInterval and
+// FirstByteTimeout on the first compressed write. It is called with g.mu held, once the headers have
+// been sent from the handler's goroutine, so the timer only ever writes the
+// body.
+func (g *gzipWriter) startTimer() {
+	g.firstWrite = time.Now()
+	if next := g.nextFlush(); !next.IsZero() {
+		g.timer = time.AfterFunc(time.Until(next), g.flushOnTimer)
+	}
+}
+
+// nextFlush returns when the timer should end the deflate block, or the zero
+// time when it should not.
+func (g *gzipWriter) nextFlush() time.Time {
+	var next time.Time
+	if timeout := g.handler.FirstByteTimeout; timeout > 0 && !g.synced {
+		next = g.firstWrite.Add(timeout)
+	}
+	if interval := g.handler.FlushPolicy.interval; interval > 0 {
+		last := g.lastSync
+		if last.IsZero() {
+			last = g.firstWrite
+		}
+		if due := last.Add(interval); next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// flushOnTimer ends the deflate block from the timer's goroutine once it is
+// due, and rearms the timer for the next one.
+func (g *gzipWriter) flushOnTimer() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.timer == nil || g.passthrough || g.writer == nil {
+		return
+	}
+	next := g.nextFlush()
+	if next.IsZero() {
+		return
+	}
+	if wait := time.Until(next); wait > 0 {
+		g.timer.Reset(wait)
+		return
+	}
+	if g.unflushed {
+		_ = g.flush(true)
+	} else {
+		// nothing was written since the last block, wait for another interval
+		g.lastSync = time.Now()
+	}
+	if next := g.nextFlush(); !next.IsZero() {
+		g.timer.Reset(time.Until(next))
+	}
+}
+
+// stopTimer stops the timer for good, so the gzip writer can be closed and
+// released safely. It is safe to call twice.
+func (g *gzipWriter) stopTimer() {
+	if g.timer == nil {
+		return
+	}
+	g.mu.Lock()
+	g.timer.Stop()
+	g.timer = nil
+	g.mu.Unlock()
+}
@@ -0,0 +1,7 @@
+//go:build !gzipminimal
+
+package gzip
+
+// minimalBuild compiles out the Accept-Encoding cache and rejects the options
+// the *_minimal.go files leave out; build with -tags gzipminimal to set it.
+const minimalBuild = false
@@ -0,0 +1,8 @@
+//go:build gzipminimal
+
+package gzip
+
+// minimalBuild compiles out the Accept-Encoding cache and rejects the options
+// the *_minimal.go files leave out; it is set when building with
+// -tags gzipminimal.
+const minimalBuild = true
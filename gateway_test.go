@@ -0,0 +1,256 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// gatewayUpstream plays the application behind a gateway in GatewayMode.
+func gatewayUpstream(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "upstream saw Accept-Encoding %q", req.Header.Get("Accept-Encoding"))
+	})
+	mux.HandleFunc("/encoded", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		_, _ = w.Write([]byte("opaque"))
+	})
+	mux.HandleFunc("/gzipped", func(w http.ResponseWriter, req *http.Request) {
+		// an upstream compressing whatever the client accepts
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipBytes(testResponse))
+	})
+	mux.HandleFunc("/unlabeled", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(gzipBytes(testResponse))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		fmt.Fprintf(w, "%q %s", req.Header.Get("Content-Encoding"), data)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: tick\n\n")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func gzipBytes(s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte(s))
+	_ = gz.Close()
+	return buf.Bytes()
+}
+
+func newGateway(t *testing.T) *httptest.Server {
+	target, _ := url.Parse(gatewayUpstream(t).URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Director = WrapDirector(proxy.Director)
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, GatewayMode()))
+	router.Any("/*path", gin.WrapH(proxy))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGatewayMode(t *testing.T) {
+	tests := []struct {
+		name                    string
+		method                  string
+		path                    string
+		header                  http.Header
+		body                    []byte
+		expectedContentEncoding string
+		expectedBody            string
+	}{
+		{
+			name: "compresses once", method: "GET", path: "/plain",
+			header:                  http.Header{"Accept-Encoding": {"gzip, br"}},
+			expectedContentEncoding: "gzip",
+			expectedBody:            `upstream saw Accept-Encoding "identity"`,
+		},
+		{
+			name: "forwards Accept-Encoding when not compressing", method: "GET", path: "/plain",
+			header:       http.Header{"Accept-Encoding": {"br"}},
+			expectedBody: `upstream saw Accept-Encoding "br"`,
+		},
+		{
+			name: "passes upstream encoding through", method: "GET", path: "/encoded",
+			header:                  http.Header{"Accept-Encoding": {"gzip, br"}},
+			expectedContentEncoding: "br",
+			expectedBody:            "opaque",
+		},
+		{
+			name: "passes upstream gzip through", method: "GET", path: "/gzipped",
+			header:                  http.Header{"Accept-Encoding": {"gzip"}},
+			expectedContentEncoding: "gzip",
+			expectedBody:            testResponse,
+		},
+		{
+			name: "decodes upstream gzip for identity clients", method: "GET", path: "/gzipped",
+			header:       http.Header{"Accept-Encoding": {"identity"}},
+			expectedBody: testResponse,
+		},
+		{
+			name: "decodes upstream gzip for clients without gzip", method: "GET", path: "/gzipped",
+			header:       http.Header{"Accept-Encoding": {"br"}},
+			expectedBody: testResponse,
+		},
+		{
+			name: "passes unlabeled compressed bodies through", method: "GET", path: "/unlabeled",
+			header:       http.Header{"Accept-Encoding": {"gzip"}},
+			expectedBody: string(gzipBytes(testResponse)),
+		},
+		{
+			name: "decompresses request bodies", method: "POST", path: "/echo",
+			header:                  http.Header{"Accept-Encoding": {"gzip"}, "Content-Encoding": {"gzip"}},
+			body:                    gzipBytes("request body"),
+			expectedContentEncoding: "gzip",
+			expectedBody:            `"" request body`,
+		},
+		{
+			name: "tolerates mislabeled request bodies", method: "POST", path: "/echo",
+			header:                  http.Header{"Accept-Encoding": {"gzip"}, "Content-Encoding": {"gzip"}},
+			body:                    []byte("plain body"),
+			expectedContentEncoding: "gzip",
+			expectedBody:            `"" plain body`,
+		},
+		{
+			name: "leaves event streams alone", method: "GET", path: "/events",
+			header:       http.Header{"Accept-Encoding": {"gzip"}, "Accept": {"text/event-stream"}},
+			expectedBody: "data: tick\n\n",
+		},
+	}
+
+	gateway := newGateway(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), tt.method, gateway.URL+tt.path, bytes.NewReader(tt.body))
+			req.Header = tt.header
+			res, err := gateway.Client().Transport.RoundTrip(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer res.Body.Close()
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, tt.expectedContentEncoding, res.Header.Get("Content-Encoding"))
+			var body io.Reader = res.Body
+			if tt.expectedContentEncoding == "gzip" {
+				gr, err := gzip.NewReader(res.Body)
+				if !assert.NoError(t, err) {
+					return
+				}
+				body = gr
+			}
+			data, err := io.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, string(data))
+		})
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithTranscode()))
+	router.Any("/", func(c *gin.Context) {
+		c.Header("Content-Encoding", "gzip")
+		c.Header("Content-Length", strconv.Itoa(len(gzipBytes(testResponse))))
+		c.Status(http.StatusOK)
+		if c.Request.Method != http.MethodHead {
+			_, _ = c.Writer.Write(gzipBytes(testResponse))
+		}
+	})
+
+	tests := []struct {
+		name                    string
+		method                  string
+		acceptEncoding          string
+		expectedContentEncoding string
+		expectedBody            string
+	}{
+		{"decodes for identity clients", http.MethodGet, "", "", testResponse},
+		{"passes gzip through", http.MethodGet, "gzip", "gzip", string(gzipBytes(testResponse))},
+		{"handles bodiless responses", http.MethodHead, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), tt.method, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+			if tt.expectedContentEncoding == "" {
+				assert.Empty(t, w.Header().Get("Content-Length"))
+				assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestTranscodeStream(t *testing.T) {
+	body := strings.Repeat(testResponse, 1000)
+	encoded := gzipBytes(body)
+	var errs []*gin.Error
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		errs = c.Errors
+	}, Gzip(DefaultCompression, WithTranscode()))
+	router.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Encoding", "gzip")
+		for _, chunk := range [][]byte{encoded[:10], encoded[10:]} {
+			_, err := c.Writer.Write(chunk)
+			assert.NoError(t, err)
+		}
+		// like httputil.ReverseProxy copying the trailers of the upstream
+		c.Writer.Header().Set(http.TrailerPrefix+"X-Chunks", "3")
+	})
+	router.GET("/corrupt", func(c *gin.Context) {
+		c.Header("Content-Encoding", "gzip")
+		_, _ = c.Writer.Write(encoded[:len(encoded)/2])
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for path, expected := range map[string]string{"/stream": body, "/corrupt": ""} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL+path, nil)
+		req.Header.Set("Accept-Encoding", "identity")
+		res, err := server.Client().Transport.RoundTrip(req)
+		if !assert.NoError(t, err, path) {
+			continue
+		}
+		data, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Empty(t, res.Header.Get("Content-Encoding"), path)
+		if expected == "" {
+			assert.NotEmpty(t, errs, path)
+			continue
+		}
+		assert.Empty(t, errs, path)
+		assert.Equal(t, expected, string(data), path)
+	}
+}
@@ -1,7 +1,18 @@
 package gzip
 
 import (
+	"bufio"
 	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,29 +22,639 @@ const (
 	BestSpeed          = gzip.BestSpeed
 	DefaultCompression = gzip.DefaultCompression
 	NoCompression      = gzip.NoCompression
+	HuffmanOnly        = gzip.HuffmanOnly
 )
 
+// osUnknown is the gzip header OS byte for an unknown operating system.
+const osUnknown = 255
+
+// Trailers set by WithStatsTrailers and WithLengthTrailer.
+const (
+	headerOriginalSize         = "X-Original-Size"
+	headerCompressionRatio     = "X-Compression-Ratio"
+	headerContentLengthTrailer = "X-Content-Length"
+)
+
+// Gzip returns the middleware compressing responses at level. It panics when
+// level or the options are invalid, use New to get an error instead.
 func Gzip(level int, options ...Option) gin.HandlerFunc {
 	return newGzipHandler(level, options...).Handle
 }
 
+// New is like Gzip but returns an error for an invalid level or options.
+func New(level int, options ...Option) (gin.HandlerFunc, error) {
+	handler, err := buildGzipHandler(level, options...)
+	if err != nil {
+		return nil, err
+	}
+	return handler.Handle, nil
+}
+
+// ResponseWriter is implemented by the writer the middleware installs as
+// c.Writer for responses it may compress.
+type ResponseWriter interface {
+	gin.ResponseWriter
+
+	// CanFlush reports whether the underlying writer implements http.Flusher.
+	// When it does not, Flush still hands all compressed data to it but cannot
+	// push that data to the client.
+	CanFlush() bool
+}
+
+var _ ResponseWriter = (*gzipWriter)(nil)
+
 type gzipWriter struct {
 	gin.ResponseWriter
-	writer *gzip.Writer
+	writer  *pooledWriter
+	pool    *writerPool
+	handler *gzipHandler
+	ctx     *gin.Context
+	base    http.ResponseWriter
+	// coding is the Content-Encoding of the writer, gzip or dcz.
+	coding string
+
+	// decided is set once the response headers have been inspected on the
+	// first write, passthrough when the body must be written untouched.
+	decided     bool
+	passthrough bool
+	wroteBody   bool
+
+	// forced is set when Options.ResponseRewriter chose compression, which
+	// ends the response pipeline.
+	forced bool
+
+	// originalSize counts the uncompressed bytes written by the handler.
+	originalSize int
+
+	// head holds the first chunk of the body while decide runs on a Write,
+	// for the response stages that sniff the content.
+	head []byte
+
+	// snapshot holds the headers decide changes as they were before, so
+	// abandon puts back exactly what the handler and other middlewares set.
+	snapshot headerSnapshot
+
+	// mu guards the compressor and the wrapped writer against the timer
+	// behind Options.FlushPolicy and Options.FirstByteTimeout, which flushes
+	// from its own goroutine.
+	mu    sync.Mutex
+	timer *time.Timer
+
+	// firstWrite is when the body was first written, lastSync when a deflate
+	// block was last ended, synced once one was, and unflushed is set while
+	// the current block holds data, for the timer.
+	firstWrite time.Time
+	lastSync   time.Time
+	synced     bool
+	unflushed  bool
+
+	// exposeSizes is set when the request may see the compression statistics
+	// of Options.StatsTrailers.
+	exposeSizes bool
+
+	// etagVariant is set when the request was conditional on the ETag of a
+	// compressed response, with Options.ETagVariant.
+	etagVariant bool
+
+	// pending holds the start of the body back while it is shorter than
+	// Options.MinLength, complete is set once the handler has returned.
+	pending  []byte
+	complete bool
 }
 
-func (g *gzipWriter) WriteString(s string) (int, error) {
+// decide runs the response pipeline once, before the first byte of the body
+// goes out. The compression headers are only set here, once the handler's
+// own headers are known, so a Content-Encoding it set is never overridden.
+func (g *gzipWriter) decide() {
+	if g.decided {
+		return
+	}
+	g.decided = true
+
+	start := time.Now()
+	reason := g.handler.responseSkipReason(g)
+	g.ctx.Set(DecisionDurationKey, time.Since(start))
+	if reason != "" {
+		g.passthrough = true
+		g.notModified()
+		g.handler.emit(g.ctx, Event{Kind: EventSkipped, Reason: reason})
+		return
+	}
+
+	g.ctx.Set(EncodingKey, g.coding)
+	g.snapshot = snapshotHeader(g.Header())
+	// a Content-Length set by the handler counts the uncompressed body
 	g.Header().Del("Content-Length")
-	return g.writer.Write([]byte(s))
+	g.Header().Set("Content-Encoding", g.coding)
+	addVary(g.Header(), "Accept-Encoding")
+	if g.handler.dictionaries != nil {
+		addVary(g.Header(), "Available-Dictionary")
+	}
+	if g.handler.ETagVariant {
+		g.variantETag()
+	}
+	if !g.handler.KeepAcceptRanges && g.Header().Get("Accept-Ranges") != "" {
+		g.Header().Set("Accept-Ranges", "none")
+	}
+	for _, name := range g.trailers() {
+		g.Header().Add("Trailer", name)
+	}
+}
+
+// etagVariantSuffixes end the entity tags of compressed responses with
+// Options.ETagVariant, one for each coding.
+var etagVariantSuffixes = [...]string{`-gzip"`, `-dcz"`}
+
+// variantETag appends the content coding to the ETag, weak or strong.
+func (g *gzipWriter) variantETag() {
+	suffix := "-" + g.coding + `"`
+	if etag := g.Header().Get("ETag"); strings.HasSuffix(etag, `"`) && !strings.HasSuffix(etag, suffix) {
+		g.Header().Set("ETag", etag[:len(etag)-1]+suffix)
+	}
+}
+
+// notModified names the compressed representation in the ETag of a 304 Not
+// Modified answering a request conditional on it, see stripETagVariants.
+func (g *gzipWriter) notModified() {
+	if g.etagVariant && g.Status() == http.StatusNotModified {
+		g.variantETag()
+	}
+}
+
+// stripETagVariants removes the suffix Options.ETagVariant appends from the
+// entity tags of conditional request headers, so handlers recognize the tags
+// of their own compressed responses. It reports whether there was one.
+func stripETagVariants(header http.Header) bool {
+	found := false
+	for _, key := range [...]string{"If-None-Match", "If-Match"} {
+		for i, v := range header[key] {
+			for _, suffix := range etagVariantSuffixes {
+				if strings.Contains(v, suffix) {
+					v = strings.ReplaceAll(v, suffix, `"`)
+					header[key][i] = v
+					found = true
+				}
+			}
+		}
+	}
+	return found
+}
+
+// trailers returns the names of the trailers sent with compressed responses.
+func (g *gzipWriter) trailers() []string {
+	var names []string
+	if g.handler.StatsTrailers && g.exposeSizes {
+		names = append(names, headerOriginalSize, headerCompressionRatio)
+	}
+	if name := g.handler.LengthTrailer; name != "" {
+		names = append(names, name)
+	}
+	if name := g.handler.OriginalLengthHeader; name != "" {
+		names = append(names, name)
+	}
+	return names
+}
+
+// abandon hands the response back to the wrapped writer when a panic unwinds
+// through the middleware, so that a recovery handler registered before it
+// writes its error page uncompressed instead of into a released gzip writer.
+func (g *gzipWriter) abandon(c *gin.Context) {
+	g.stopTimer()
+	c.Writer = g.ResponseWriter
+	if !g.decided || g.passthrough || g.ResponseWriter.Written() {
+		return
+	}
+	g.snapshot.restore(g.Header())
+	c.Set(EncodingKey, "")
+}
+
+// snapshotHeaders are the headers decide changes, in canonical form.
+var snapshotHeaders = [...]string{"Content-Encoding", "Content-Length", "Vary", "Etag", "Accept-Ranges", "Trailer"}
+
+// headerSnapshot records the values of snapshotHeaders, nil for absent ones.
+// The slices are kept as they are: Set replaces them and Add only appends
+// beyond their length, so they keep the recorded values without a copy.
+type headerSnapshot [len(snapshotHeaders)][]string
+
+func snapshotHeader(header http.Header) headerSnapshot {
+	var s headerSnapshot
+	for i, key := range snapshotHeaders {
+		s[i] = header[key]
+	}
+	return s
+}
+
+// restore sets the recorded headers back to their recorded values.
+func (s *headerSnapshot) restore(header http.Header) {
+	for i, key := range snapshotHeaders {
+		if s[i] == nil {
+			delete(header, key)
+			continue
+		}
+		header[key] = s[i]
+	}
+}
+
+// setTrailers fills in the trailers declared by decide once the gzip stream
+// has been closed.
+//
+// The compressed stream may still sit in the writer's output buffer, in which
+// case no header has been sent yet and the values go out as plain headers.
+func (g *gzipWriter) setTrailers() {
+	compressed := max(g.ResponseWriter.Size(), 0) + g.writer.Buffered()
+	headersPending := !g.ResponseWriter.Written()
+	set := func(name, value string) {
+		if headersPending {
+			removeHeaderValue(g.Header(), "Trailer", name)
+		}
+		g.Header().Set(name, value)
+	}
+
+	if g.handler.StatsTrailers && g.exposeSizes {
+		set(headerOriginalSize, strconv.Itoa(g.originalSize))
+		if compressed > 0 {
+			ratio := float64(g.originalSize) / float64(compressed)
+			set(headerCompressionRatio, strconv.FormatFloat(ratio, 'f', 2, 64))
+		}
+	}
+	if name := g.handler.LengthTrailer; name != "" {
+		set(name, strconv.Itoa(compressed))
+	}
+	if name := g.handler.OriginalLengthHeader; name != "" {
+		set(name, strconv.Itoa(g.originalSize))
+	}
+}
+
+// holdTrailers removes the values of the trailers the handler declared from
+// the header and returns them. The middleware sends the header only once the
+// handler has returned when it holds the body back, by which time the values
+// are set, and they must not go out as header fields.
+func (g *gzipWriter) holdTrailers() http.Header {
+	var held http.Header
+	own := g.trailers()
+	for i, name := range own {
+		own[i] = http.CanonicalHeaderKey(name)
+	}
+	for _, value := range g.Header().Values("Trailer") {
+		for rest, more := value, true; more; {
+			var name string
+			name, rest, more = strings.Cut(rest, ",")
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			values, ok := g.Header()[name]
+			if !ok || slices.Contains(own, name) {
+				continue
+			}
+			if held == nil {
+				held = make(http.Header)
+			}
+			held[name] = values
+			delete(g.Header(), name)
+		}
+	}
+	return held
+}
+
+// addVary adds value to the Vary header unless it is listed already.
+func addVary(header http.Header, value string) {
+	if !headerHasToken(header, "Vary", value) && !headerHasToken(header, "Vary", "*") {
+		header.Add("Vary", value)
+	}
+}
+
+// removeHeaderValue removes value from the comma-separated list in header key,
+// deleting the header once it is empty.
+func removeHeaderValue(header http.Header, key, value string) {
+	var kept []string
+	for _, v := range header.Values(key) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" && !strings.EqualFold(item, value) {
+				kept = append(kept, item)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		header.Del(key)
+		return
+	}
+	header[http.CanonicalHeaderKey(key)] = []string{strings.Join(kept, ", ")}
+}
+
+// WriteString writes s through a pooled buffer instead of converting it to a
+// new byte slice.
+func (g *gzipWriter) WriteString(s string) (int, error) {
+	buf := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(buf)
+	written := 0
+	for len(s) > 0 {
+		n := copy(*buf, s)
+		m, err := g.Write((*buf)[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		s = s[n:]
+	}
+	return written, nil
 }
 
 func (g *gzipWriter) Write(data []byte) (int, error) {
+	if g.writer == nil {
+		return g.writeReleased(data)
+	}
+	if !g.decided {
+		held := len(g.pending)
+		if g.holdBack(data) {
+			return len(data), nil
+		}
+		if held > 0 {
+			data = append(g.pending, data...)
+			g.pending = nil
+		}
+		g.head = data
+		g.decide()
+		g.head = nil
+		n, err := g.write(data)
+		return max(n-held, 0), err
+	}
+	return g.write(data)
+}
+
+// errWriterReleased is returned by writes into a compressed response that was
+// already finished.
+var errWriterReleased = errors.New("gzip: write after the compressed response was finished")
+
+// writeReleased handles writes once the compressor went back to its pool,
+// e.g. gin's default 404 page written after the middleware returned. They
+// never reach the compressor, which may serve another request by now:
+// uncompressed responses take them as they are, compressed ones are complete.
+func (g *gzipWriter) writeReleased(data []byte) (int, error) {
+	if g.decided && !g.passthrough {
+		return 0, errWriterReleased
+	}
+	return g.ResponseWriter.Write(data)
+}
+
+// holdBack buffers data while the body is still shorter than MinLength and
+// its length is not declared by Content-Length, reporting whether it did.
+func (g *gzipWriter) holdBack(data []byte) bool {
+	minLength := g.handler.MinLength
+	if minLength <= 0 || len(g.pending)+len(data) >= minLength || g.Header().Get("Content-Length") != "" {
+		return false
+	}
+	g.pending = append(g.pending, data...)
+	return true
+}
+
+// commit decides now, because the headers are about to be sent or the
+// handler is done, and writes out the body held back so far.
+func (g *gzipWriter) commit() {
+	if g.decided || g.writer == nil {
+		return
+	}
+	pending := g.pending
+	g.pending = nil
+	g.head = pending
+	g.decide()
+	g.head = nil
+	if len(pending) > 0 {
+		_, _ = g.write(pending)
+	}
+}
+
+// knownLength returns the length of the whole body if it is known yet, or -1.
+func (g *gzipWriter) knownLength() int {
+	if v := g.Header().Get("Content-Length"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	if g.complete {
+		return len(g.head)
+	}
+	return -1
+}
+
+func (g *gzipWriter) write(data []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.passthrough {
+		return g.ResponseWriter.Write(data)
+	}
 	g.Header().Del("Content-Length")
-	return g.writer.Write(data)
+	if !g.wroteBody && (g.handler.FirstByteTimeout > 0 || g.handler.FlushPolicy.interval > 0) {
+		// the timer writes from its own goroutine, which must not race the
+		// handler's header changes, so the headers go out now, as they
+		// would on the first write without the middleware
+		g.ResponseWriter.WriteHeaderNow()
+		g.startTimer()
+	}
+	g.wroteBody = true
+	g.unflushed = true
+	if f := g.handler.FaultInjection; f != nil {
+		allowed, faultErr := f.limitWrite(g.originalSize, len(data))
+		if faultErr != nil {
+			n, _ := g.writer.Write(data[:allowed])
+			g.originalSize += n
+			return n, faultErr
+		}
+	}
+	n, err := g.writer.Write(data)
+	g.originalSize += n
+	return n, err
+}
+
+var copyBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 32*1024)
+		return &b
+	},
+}
+
+// writerOnly hides ReadFrom so io.CopyBuffer does not call back into it.
+type writerOnly struct {
+	io.Writer
+}
+
+// ReadFrom implements io.ReaderFrom so io.Copy, and with it c.File and
+// http.ServeContent, streams through the compressor with a pooled buffer.
+func (g *gzipWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !g.handler.ContentSniffing && g.handler.MinLength <= 0 {
+		g.commit()
+	}
+	// otherwise the first Write below decides once data has been read
+	if g.passthrough {
+		return io.Copy(g.ResponseWriter, r)
+	}
+	buf := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(buf)
+	return io.CopyBuffer(writerOnly{g}, r, *buf)
+}
+
+// Flush emits everything compressed so far as a complete deflate block before
+// flushing the underlying writer, so streamed chunks reach the client. With
+// FlushBuffered or FlushInterval the block is not ended, see FlushPolicy.
+func (g *gzipWriter) Flush() {
+	_ = g.FlushError()
+}
+
+// FlushError is Flush reporting the first error, which http.ResponseController
+// prefers over Flush.
+func (g *gzipWriter) FlushError() error {
+	g.commit()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.flush(!g.handler.FlushPolicy.buffered)
+}
+
+// flushBlock flushes like FlushImmediate does, whatever the FlushPolicy.
+func (g *gzipWriter) flushBlock() error {
+	g.commit()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.flush(true)
+}
+
+// flush hands the compressed data to the underlying writer, ending the current
+// deflate block first when sync is set, and flushes that writer. It is called
+// with g.mu held.
+func (g *gzipWriter) flush(sync bool) error {
+	var err error
+	switch {
+	case g.passthrough || g.writer == nil:
+	case sync:
+		g.synced = true
+		g.unflushed = false
+		if !g.firstWrite.IsZero() {
+			g.lastSync = time.Now()
+		}
+		err = g.writer.Flush()
+	default:
+		err = g.writer.FlushBuffer()
+	}
+	if !g.CanFlush() {
+		// gin's Flush would panic; send the headers like it does and stop
+		g.ResponseWriter.WriteHeaderNow()
+		return err
+	}
+	if f, ok := g.base.(interface{ FlushError() error }); ok && wrapsBase(g.ResponseWriter) {
+		// gin's Flush would drop the error; do its work here instead
+		g.ResponseWriter.WriteHeaderNow()
+		if flushErr := f.FlushError(); err == nil {
+			err = flushErr
+		}
+		return err
+	}
+	g.ResponseWriter.Flush()
+	return err
+}
+
+func (g *gzipWriter) CanFlush() bool {
+	_, ok := g.base.(http.Flusher)
+	return ok
+}
+
+// CloseNotify serves legacy callers of the deprecated http.CloseNotifier,
+// such as reverse proxies; new code should watch the request context. When
+// the underlying writer cannot notify, which would make gin's CloseNotify
+// panic, the channel fires once the request context is done instead.
+func (g *gzipWriter) CloseNotify() <-chan bool {
+	if _, ok := g.base.(http.CloseNotifier); ok {
+		return g.ResponseWriter.CloseNotify()
+	}
+	ch := make(chan bool, 1)
+	context.AfterFunc(g.ctx.Request.Context(), func() { ch <- true })
+	return ch
+}
+
+// baseWriter returns the http.ResponseWriter at the bottom of a chain of
+// wrappers such as gin's own writer. Optional interfaces are looked up there,
+// since gin's writer has methods like Flush whether or not it supports them.
+func baseWriter(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return w
+		}
+		w = u.Unwrap()
+	}
+}
+
+// wrapsBase reports whether w wraps the base writer directly, so calling the
+// base skips no other wrapper's Flush.
+func wrapsBase(w http.ResponseWriter) bool {
+	u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+	if !ok {
+		return false
+	}
+	_, nested := u.Unwrap().(interface{ Unwrap() http.ResponseWriter })
+	return !nested
+}
+
+// Hijack hands the connection over to the handler, which then owns the whole
+// response, so nothing is compressed or written on its behalf afterwards.
+func (g *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if _, ok := g.base.(http.Hijacker); !ok {
+		// gin's Hijack would panic
+		return nil, nil, http.ErrNotSupported
+	}
+	g.stopTimer()
+	g.mu.Lock()
+	g.decided = true
+	g.passthrough = true
+	g.pending = nil
+	g.mu.Unlock()
+	return g.ResponseWriter.Hijack()
+}
+
+// Push initiates an HTTP/2 server push, or returns http.ErrNotSupported when
+// the underlying writer cannot push.
+func (g *gzipWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := g.base.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the wrapped writer so http.ResponseController reaches the
+// capabilities gin's interface does not cover, such as write deadlines.
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipWriter) WriteHeaderNow() {
+	g.commit()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ResponseWriter.WriteHeaderNow()
+}
+
+// Size is gin's Size, read under g.mu since the timer behind
+// Options.FlushPolicy and Options.FirstByteTimeout writes the body.
+func (g *gzipWriter) Size() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.ResponseWriter.Size()
+}
+
+// Written is gin's Written, read under g.mu like Size.
+func (g *gzipWriter) Written() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.ResponseWriter.Written()
 }
 
 // Fix: https://github.com/mholt/caddy/issues/38
 func (g *gzipWriter) WriteHeader(code int) {
-	g.Header().Del("Content-Length")
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// informational responses such as 103 Early Hints go out at once and
+		// leave the final response, and whether it is compressed, alone;
+		// gin's writer would take them for the final status
+		g.base.WriteHeader(code)
+		return
+	}
+	// Content-Length is kept until decide, which drops it only when the
+	// body gets compressed, so small and skipped responses keep theirs
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	g.ResponseWriter.WriteHeader(code)
 }
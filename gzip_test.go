@@ -1,20 +1,32 @@
 package gzip
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"flag"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"net/textproto"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
 )
 
 const (
@@ -22,6 +34,26 @@ const (
 	testReverseResponse = "Gzip Test Reverse Response "
 )
 
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// TestMain fails the run when a test leaks goroutines or leaves gzip writers
+// checked out of their pool. Build with -tags gzipdebug to also panic on the
+// spot when an internal invariant breaks.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 {
+		if n := outstandingWriters.Load(); n != 0 {
+			fmt.Fprintf(os.Stderr, "%d gzip writers were never returned to their pool\n", n)
+			code = 1
+		}
+		if err := goleak.Find(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
 type rServer struct{}
 
 func (s *rServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -44,9 +76,10 @@ func (c *closeNotifyingRecorder) CloseNotify() <-chan bool {
 	return c.closed
 }
 
-func newServer() *gin.Engine {
+func newServer(t *testing.T) *gin.Engine {
 	// init reverse proxy server
 	rServer := httptest.NewServer(new(rServer))
+	t.Cleanup(rServer.Close)
 	target, _ := url.Parse(rServer.URL)
 	rp := httputil.NewSingleHostReverseProxy(target)
 
@@ -67,7 +100,7 @@ func TestGzip(t *testing.T) {
 	req.Header.Add("Accept-Encoding", "gzip")
 
 	w := httptest.NewRecorder()
-	r := newServer()
+	r := newServer(t)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, w.Code, 200)
@@ -115,6 +148,8 @@ func TestExcludedPathsAndExtensions(t *testing.T) {
 	}{
 		{"/api/books", WithExcludedPaths([]string{"/api/"}), "", "", "this is books!", ""},
 		{"/index.html", WithExcludedExtensions([]string{".html"}), "", "", "this is a HTML!", ""},
+		{"/index.html", WithAdditionalExcludedExtensions([]string{".html"}), "", "", "this is a HTML!", ""},
+		{"/logo.png", WithAdditionalExcludedExtensions([]string{".html"}), "", "", "this is a PNG!", ""},
 	}
 
 	for _, tt := range tests {
@@ -142,7 +177,7 @@ func TestNoGzip(t *testing.T) {
 	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
 
 	w := httptest.NewRecorder()
-	r := newServer()
+	r := newServer(t)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, w.Code, 200)
@@ -156,7 +191,7 @@ func TestGzipWithReverseProxy(t *testing.T) {
 	req.Header.Add("Accept-Encoding", "gzip")
 
 	w := newCloseNotifyingRecorder()
-	r := newServer()
+	r := newServer(t)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, w.Code, 200)
@@ -247,3 +282,2082 @@ func TestDecompressGzipWithIncorrectData(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func TestGzipWithMultipleContentEncodings(t *testing.T) {
+	tests := []struct {
+		name                    string
+		setEncoding             func(header http.Header)
+		expectedContentEncoding []string
+	}{
+		{
+			name: "appended values",
+			setEncoding: func(header http.Header) {
+				header.Add("Content-Encoding", "br")
+				header.Add("Content-Encoding", "gzip")
+			},
+			expectedContentEncoding: []string{"br", "gzip"},
+		},
+		{
+			name:                    "comma separated value",
+			setEncoding:             func(header http.Header) { header.Set("Content-Encoding", "br, gzip") },
+			expectedContentEncoding: []string{"br, gzip"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression))
+			router.GET("/", func(c *gin.Context) {
+				tt.setEncoding(c.Writer.Header())
+				c.String(200, testResponse)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectedContentEncoding, w.Header().Values("Content-Encoding"))
+			assert.Equal(t, testResponse, w.Body.String())
+			assert.Equal(t, strconv.Itoa(len(testResponse)), w.Header().Get("Content-Length"))
+		})
+	}
+}
+
+func TestGzipGoldenOutput(t *testing.T) {
+	if backendName != "compress/gzip" {
+		t.Skipf("golden files are generated with compress/gzip, not %s", backendName)
+	}
+
+	tests := []struct {
+		name    string
+		level   int
+		handler gin.HandlerFunc
+	}{
+		{"small_default", DefaultCompression, func(c *gin.Context) {
+			c.String(200, testResponse)
+		}},
+		{"small_best_speed", BestSpeed, func(c *gin.Context) {
+			c.String(200, testResponse)
+		}},
+		{"repeated_best_compression", BestCompression, func(c *gin.Context) {
+			c.String(200, strings.Repeat(testResponse, 4096))
+		}},
+		{"json_default", DefaultCompression, func(c *gin.Context) {
+			c.JSON(200, gin.H{"message": testResponse, "items": []int{1, 2, 3}})
+		}},
+		{"multiple_writes_with_flush", DefaultCompression, func(c *gin.Context) {
+			for i := 0; i < 3; i++ {
+				_, _ = c.Writer.WriteString(testResponse)
+				c.Writer.Flush()
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			router := gin.New()
+			router.Use(Gzip(tt.level, WithDeterministic()))
+			router.GET("/", tt.handler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			golden := filepath.Join("testdata", tt.name+".gz")
+			if *updateGolden {
+				assert.NoError(t, os.WriteFile(golden, w.Body.Bytes(), 0o600))
+			}
+			expected, err := os.ReadFile(golden)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, w.Body.Bytes())
+		})
+	}
+}
+
+func TestExcludedMethods(t *testing.T) {
+	tests := []struct {
+		method                  string
+		options                 []Option
+		expectedContentEncoding string
+	}{
+		{http.MethodHead, nil, ""},
+		{http.MethodOptions, nil, ""},
+		{http.MethodGet, nil, "gzip"},
+		{http.MethodHead, []Option{WithExcludedMethods(nil)}, "gzip"},
+		{http.MethodPost, []Option{WithExcludedMethods([]string{"post"})}, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), tt.method, "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, tt.options...))
+		router.Handle(tt.method, "/", func(c *gin.Context) {
+			c.Header("Content-Length", strconv.Itoa(len(testResponse)))
+			c.String(http.StatusOK, testResponse)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+		if tt.expectedContentEncoding == "" {
+			assert.Equal(t, strconv.Itoa(len(testResponse)), w.Header().Get("Content-Length"))
+		}
+	}
+}
+
+func TestGzipHeaderOS(t *testing.T) {
+	tests := []struct {
+		options    []Option
+		expectedOS byte
+	}{
+		{nil, osUnknown},
+		{[]Option{WithHeaderOS(3)}, 3},
+		{[]Option{WithDeterministic(), WithHeaderOS(0)}, 0},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, tt.options...))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, testResponse)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		gr, err := gzip.NewReader(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expectedOS, gr.Header.OS)
+	}
+}
+
+func TestCustomShouldCompressFn(t *testing.T) {
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	req.Header.Add("X-Internal", "1")
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithCustomShouldCompressFn(func(c *gin.Context) bool {
+		return c.GetHeader("X-Internal") == ""
+	})))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, testResponse, w.Body.String())
+}
+
+func TestResponseShouldCompressFn(t *testing.T) {
+	allowTransform := func(status int, header http.Header) bool {
+		return status == http.StatusOK && header.Get("Cache-Control") != "no-transform"
+	}
+
+	tests := []struct {
+		name                    string
+		handler                 gin.HandlerFunc
+		expectedContentEncoding string
+	}{
+		{"compressed", func(c *gin.Context) {
+			c.String(http.StatusOK, testResponse)
+		}, "gzip"},
+		{"vetoed by header", func(c *gin.Context) {
+			c.Header("Cache-Control", "no-transform")
+			c.String(http.StatusOK, testResponse)
+		}, ""},
+		{"vetoed by status", func(c *gin.Context) {
+			c.String(http.StatusNotFound, testResponse)
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, WithResponseShouldCompressFn(allowTransform)))
+			router.GET("/", tt.handler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+			if tt.expectedContentEncoding == "" {
+				assert.Equal(t, "", w.Header().Get("Vary"))
+				assert.Equal(t, testResponse, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestResponseRewriter(t *testing.T) {
+	rewriter := func(header http.Header, firstChunk []byte) Decision {
+		switch {
+		case bytes.HasPrefix(firstChunk, []byte("data:")):
+			return DecisionSkip
+		case header.Get("Content-Type") == "text/csv":
+			return DecisionCompress
+		}
+		return DecisionDefault
+	}
+
+	tests := []struct {
+		name                    string
+		contentType             string
+		body                    string
+		expectedContentEncoding string
+	}{
+		{"default", "text/plain", testResponse, "gzip"},
+		{"skipped by body", "text/plain", "data:" + testResponse, ""},
+		{"forced past min length", "text/csv", "a,b", "gzip"},
+		{"default min length", "text/plain", "a,b", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, WithMinLength(16), WithResponseRewriter(rewriter)))
+			router.GET("/", func(c *gin.Context) {
+				c.Data(http.StatusOK, tt.contentType, []byte(tt.body))
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+			if tt.expectedContentEncoding == "" {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDecompressGzipBindJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	gz, _ := gzip.NewWriterLevel(buf, gzip.DefaultCompression)
+	_, _ = gz.Write([]byte(`{"message":"` + testResponse + `"}`))
+	gz.Close()
+
+	req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", bytes.NewReader(buf.Bytes()))
+	req.Header.Add("Content-Encoding", "gzip")
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Length", strconv.Itoa(buf.Len()))
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithDecompressFn(DefaultDecompressHandle)))
+	router.POST("/", func(c *gin.Context) {
+		assert.Equal(t, int64(-1), c.Request.ContentLength)
+		assert.Equal(t, []string{"chunked"}, c.Request.TransferEncoding)
+
+		var payload struct {
+			Message string `json:"message"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			t.Fatal(err)
+		}
+		c.String(200, payload.Message)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testResponse, w.Body.String())
+	assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"), "original request must not be mutated")
+}
+
+func TestGzipStatsTrailers(t *testing.T) {
+	body := strings.Repeat(testResponse, 100)
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithStatsTrailers(),
+		WithTrustedNetworks([]string{"10.0.0.0/8"}),
+		WithTrustedRequest(func(c *gin.Context) bool {
+			return c.GetHeader("X-Debug") == "secret"
+		}),
+	))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, body)
+	})
+	assert.NoError(t, router.SetTrustedProxies([]string{"192.0.2.0/24"}))
+
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor string
+		debug        string
+		trusted      bool
+	}{
+		{"10.1.2.3:1234", "", "", true},
+		{"192.0.2.9:1234", "10.1.2.3", "", true},
+		{"203.0.113.7:1234", "", "secret", true},
+		{"127.0.0.1:1234", "", "", false},
+		{"[::1]:1234", "", "", false},
+		{"192.0.2.9:1234", "", "", false},
+		{"203.0.113.7:1234", "10.1.2.3", "", false},
+		{"203.0.113.7:1234", "", "", false},
+		{"203.0.113.7:1234", "", "guess", false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		req.Header.Set("X-Debug", tt.debug)
+		req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		req.RemoteAddr = tt.remoteAddr
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		res.Body.Close()
+
+		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+		if !tt.trusted {
+			assert.Empty(t, res.Header.Values("Trailer"), tt.remoteAddr)
+			assert.Empty(t, res.Trailer.Get(headerOriginalSize), tt.remoteAddr)
+			assert.Empty(t, res.Header.Get(headerOriginalSize), tt.remoteAddr)
+			continue
+		}
+		assert.Equal(t, []string{headerOriginalSize, headerCompressionRatio}, res.Header.Values("Trailer"), tt.remoteAddr)
+		assert.Equal(t, strconv.Itoa(len(body)), res.Trailer.Get(headerOriginalSize), tt.remoteAddr)
+		ratio, err := strconv.ParseFloat(res.Trailer.Get(headerCompressionRatio), 64)
+		assert.NoError(t, err)
+		assert.Greater(t, ratio, 1.0)
+	}
+
+	_, err := New(DefaultCompression, WithTrustedNetworks([]string{"10.0.0.0/33"}))
+	assert.ErrorContains(t, err, "trusted network")
+}
+
+func TestDecompressGzipMultistream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	for _, part := range []string{"first ", "second"} {
+		gz, _ := gzip.NewWriterLevel(buf, gzip.DefaultCompression)
+		_, _ = gz.Write([]byte(part))
+		gz.Close()
+	}
+
+	tests := []struct {
+		name         string
+		options      []Option
+		expectedBody string
+		expectedErr  error
+	}{
+		{"all members", nil, "first second", nil},
+		{"reject multistream", []Option{WithRejectMultistream()}, "first ", ErrMultistream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", bytes.NewReader(buf.Bytes()))
+			req.Header.Add("Content-Encoding", "gzip")
+
+			options := append([]Option{WithDecompressFn(DefaultDecompressHandle)}, tt.options...)
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, options...))
+			router.POST("/", func(c *gin.Context) {
+				data, err := io.ReadAll(c.Request.Body)
+				assert.ErrorIs(t, err, tt.expectedErr)
+				c.String(200, string(data))
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+type flushSnapshotRecorder struct {
+	*httptest.ResponseRecorder
+	snapshots [][]byte
+}
+
+func (r *flushSnapshotRecorder) Flush() {
+	r.ResponseRecorder.Flush()
+	r.snapshots = append(r.snapshots, append([]byte(nil), r.Body.Bytes()...))
+}
+
+func TestStreamJSONPages(t *testing.T) {
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/export", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/export", func(c *gin.Context) {
+		page := 0
+		err := StreamJSONPages(c, http.StatusOK, func() (interface{}, bool) {
+			page++
+			return gin.H{"page": page}, page <= 3
+		}, gin.H{"pages": 3})
+		assert.NoError(t, err)
+	})
+
+	w := &flushSnapshotRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	expected := []string{`{"page":1}`, `{"page":2}`, `{"page":3}`, `{"pages":3}`}
+	assert.Len(t, w.snapshots, len(expected))
+	for i, snapshot := range w.snapshots {
+		// every flush must leave a decodable prefix ending on a page boundary
+		gr, err := gzip.NewReader(bytes.NewReader(snapshot))
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(gr)
+		assert.Equal(t, strings.Join(expected[:i+1], "\n")+"\n", string(data))
+	}
+
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(expected, "\n")+"\n", string(data))
+}
+
+func TestGzipServeFile(t *testing.T) {
+	content := strings.Repeat(testResponse, 1000)
+	name := filepath.Join(t.TempDir(), "index.txt")
+	assert.NoError(t, os.WriteFile(name, []byte(content), 0o600))
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/file", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/file", func(c *gin.Context) {
+		_, ok := c.Writer.(io.ReaderFrom)
+		assert.True(t, ok)
+		c.File(name)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+	assert.Less(t, w.Body.Len(), len(content))
+
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(gr)
+	assert.Equal(t, content, string(body))
+}
+
+func TestGzipRangeRequests(t *testing.T) {
+	content := strings.Repeat(testResponse, 100)
+	name := filepath.Join(t.TempDir(), "index.txt")
+	assert.NoError(t, os.WriteFile(name, []byte(content), 0o600))
+
+	tests := []struct {
+		name                    string
+		rangeHeader             string
+		options                 []Option
+		expectedStatus          int
+		expectedContentEncoding string
+		expectedAcceptRanges    string
+	}{
+		{"range request", "bytes=0-9", nil, http.StatusPartialContent, "", "bytes"},
+		{"full request", "", nil, http.StatusOK, "gzip", "none"},
+		{"keep accept ranges", "", []Option{WithKeepAcceptRanges()}, http.StatusOK, "gzip", "bytes"},
+		{
+			"compress range requests", "bytes=0-9", []Option{WithCompressRangeRequests(true)},
+			http.StatusPartialContent, "gzip", "none",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/file", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+			if tt.rangeHeader != "" {
+				req.Header.Add("Range", tt.rangeHeader)
+			}
+
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, tt.options...))
+			router.GET("/file", func(c *gin.Context) {
+				c.File(name)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.expectedAcceptRanges, w.Header().Get("Accept-Ranges"))
+			if tt.expectedContentEncoding == "" {
+				assert.Equal(t, content[:10], w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGzipPartialContentResponse(t *testing.T) {
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/", func(c *gin.Context) {
+		c.Header("Content-Range", "bytes 0-9/100")
+		c.String(http.StatusPartialContent, testResponse[:10])
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, testResponse[:10], w.Body.String())
+}
+
+func TestWrapDirector(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, req.Header.Get("Accept-Encoding"))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.Director = WrapDirector(rp.Director)
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/proxy", func(c *gin.Context) {
+		rp.ServeHTTP(c.Writer, c.Request)
+	})
+
+	tests := []struct {
+		acceptEncoding           string
+		expectedContentEncoding  string
+		expectedUpstreamEncoding string
+	}{
+		{"gzip", "gzip", "identity"},
+		{"br", "", "br"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/proxy", nil)
+		req.Header.Add("Accept-Encoding", tt.acceptEncoding)
+
+		w := newCloseNotifyingRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+
+		body := w.Body.String()
+		if tt.expectedContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(w.Body)
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(gr)
+			body = string(data)
+		}
+		assert.Equal(t, tt.expectedUpstreamEncoding, body)
+	}
+}
+
+func TestProxyDirector(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, req.Header.Get("Accept-Encoding"))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	director := httputil.NewSingleHostReverseProxy(target)
+	ProxyDirector(director)
+	rewrite := &httputil.ReverseProxy{Rewrite: func(pr *httputil.ProxyRequest) {
+		pr.SetURL(target)
+	}}
+	ProxyDirector(rewrite)
+
+	for name, rp := range map[string]*httputil.ReverseProxy{"director": director, "rewrite": rewrite} {
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression))
+		router.GET("/proxy", gin.WrapH(rp))
+
+		for _, acceptEncoding := range []string{"gzip", "br"} {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/proxy", nil)
+			req.Header.Add("Accept-Encoding", acceptEncoding)
+
+			w := newCloseNotifyingRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code, name)
+			body := w.Body.String()
+			if w.Header().Get("Content-Encoding") == "gzip" {
+				gr, err := gzip.NewReader(w.Body)
+				assert.NoError(t, err)
+				data, _ := io.ReadAll(gr)
+				body = string(data)
+			}
+			assert.Equal(t, "identity", body, name)
+		}
+	}
+}
+
+func TestGzipHandlerTrailers(t *testing.T) {
+	tests := []struct {
+		name                    string
+		options                 []Option
+		expectedContentEncoding string
+	}{
+		{"streamed", nil, "gzip"},
+		{"held back", []Option{WithMinLength(1000)}, ""},
+		{"buffered", []Option{WithPoolConfig(0, 0, 4096)}, "gzip"},
+		{"with own trailers", []Option{WithPoolConfig(0, 0, 4096), WithStatsTrailers()}, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, tt.options...))
+			router.GET("/", func(c *gin.Context) {
+				c.Header("Trailer", "X-Checksum")
+				c.Status(http.StatusOK)
+				_, _ = c.Writer.WriteString(strings.Repeat("a", 200))
+				c.Header("X-Checksum", "abc")
+			})
+			server := httptest.NewServer(router)
+			defer server.Close()
+
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+			res, err := server.Client().Transport.RoundTrip(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+			assert.Equal(t, tt.expectedContentEncoding, res.Header.Get("Content-Encoding"))
+			assert.Empty(t, res.Header.Get("X-Checksum"))
+			assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+		})
+	}
+}
+
+func TestGzipLengthTrailer(t *testing.T) {
+	tests := []struct {
+		name         string
+		expectedName string
+	}{
+		{"x-compressed-length", "X-Compressed-Length"},
+		{"Content-Length", headerContentLengthTrailer},
+	}
+
+	for _, tt := range tests {
+		// serve over a real connection so the body is sent chunked
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, WithLengthTrailer(tt.name)))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, strings.Repeat(testResponse, 100))
+			c.Writer.Flush()
+		})
+		server := httptest.NewServer(router)
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		res, err := server.Client().Transport.RoundTrip(req)
+		assert.NoError(t, err)
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		res.Body.Close()
+		server.Close()
+
+		assert.Equal(t, int64(-1), res.ContentLength)
+		assert.Equal(t, strconv.Itoa(len(body)), res.Trailer.Get(tt.expectedName))
+	}
+}
+
+func TestGzipOriginalLengthHeader(t *testing.T) {
+	body := strings.Repeat(testResponse, 100)
+	const name = "X-Uncompressed-Content-Length"
+
+	tests := []struct {
+		name            string
+		options         []Option
+		expectedHeader  string
+		expectedTrailer string
+	}{
+		{"streamed", nil, "", strconv.Itoa(len(body))},
+		{"fully buffered", []Option{WithPoolConfig(0, 0, 64<<10)}, strconv.Itoa(len(body)), ""},
+		{"untrusted", []Option{WithTrustedRequest(func(*gin.Context) bool { return false })}, "", strconv.Itoa(len(body))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+			req.RemoteAddr = "203.0.113.7:1234"
+
+			options := append([]Option{WithOriginalLengthHeader(name)}, tt.options...)
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, options...))
+			router.GET("/", func(c *gin.Context) {
+				c.String(http.StatusOK, body)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+			assert.Equal(t, tt.expectedHeader, res.Header.Get(name))
+			assert.Equal(t, tt.expectedTrailer, res.Trailer.Get(name))
+			if tt.expectedTrailer == "" {
+				assert.Empty(t, res.Header.Values("Trailer"))
+			}
+		})
+	}
+}
+
+func TestGzipBuffering(t *testing.T) {
+	// random letters do not compress below the 4KB cap
+	rnd := rand.New(rand.NewSource(1))
+	noise := make([]byte, 16<<10)
+	for i := range noise {
+		noise[i] = byte('a' + rnd.Intn(26))
+	}
+
+	tests := []struct {
+		name                  string
+		body                  string
+		flush                 bool
+		expectedContentLength bool
+	}{
+		{"fits", strings.Repeat(testResponse, 100), false, true},
+		{"exceeds cap", string(noise), false, false},
+		{"flushed", strings.Repeat(testResponse, 100), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, WithBuffering(4<<10), WithStatsTrailers(),
+				WithTrustedNetworks([]string{"127.0.0.0/8", "::1/128"})))
+			router.GET("/", func(c *gin.Context) {
+				c.String(http.StatusOK, tt.body)
+				if tt.flush {
+					c.Writer.Flush()
+				}
+			})
+			server := httptest.NewServer(router)
+			defer server.Close()
+
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+			res, err := server.Client().Transport.RoundTrip(req)
+			assert.NoError(t, err)
+
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			res.Body.Close()
+
+			gr, err := gzip.NewReader(bytes.NewReader(body))
+			assert.NoError(t, err)
+			decompressed, _ := io.ReadAll(gr)
+			assert.Equal(t, tt.body, string(decompressed))
+
+			if tt.expectedContentLength {
+				assert.Equal(t, int64(len(body)), res.ContentLength)
+				assert.Equal(t, strconv.Itoa(len(tt.body)), res.Header.Get(headerOriginalSize))
+			} else {
+				assert.Equal(t, int64(-1), res.ContentLength)
+				assert.Equal(t, strconv.Itoa(len(tt.body)), res.Trailer.Get(headerOriginalSize))
+			}
+		})
+	}
+}
+
+func TestGzipHTTP10(t *testing.T) {
+	body := strings.Repeat(testResponse, 100)
+
+	tests := []struct {
+		name                    string
+		option                  Option
+		expectedContentEncoding string
+	}{
+		{"skip", WithSkipHTTP10(), ""},
+		{"buffer", WithHTTP10Buffering(64 << 10), "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, tt.option))
+			router.GET("/", func(c *gin.Context) {
+				c.String(http.StatusOK, body)
+			})
+			server := httptest.NewServer(router)
+			defer server.Close()
+
+			// net/http clients always speak HTTP/1.1, so write the request by hand
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			assert.NoError(t, err)
+			defer conn.Close()
+			_, err = io.WriteString(conn, "GET / HTTP/1.0\r\nAccept-Encoding: gzip\r\n\r\n")
+			assert.NoError(t, err)
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			raw, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedContentEncoding, res.Header.Get("Content-Encoding"))
+			assert.Equal(t, int64(len(raw)), res.ContentLength)
+			if tt.expectedContentEncoding == "gzip" {
+				gr, err := gzip.NewReader(bytes.NewReader(raw))
+				assert.NoError(t, err)
+				raw, _ = io.ReadAll(gr)
+			}
+			assert.Equal(t, body, string(raw))
+		})
+	}
+}
+
+func TestGzipDisable(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+	}{
+		{"Disable in handler", func(c *gin.Context) {
+			Disable(c)
+			c.String(http.StatusOK, testResponse)
+		}},
+		{"SkipKey in handler", func(c *gin.Context) {
+			c.Set(SkipKey, true)
+			c.String(http.StatusOK, testResponse)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression))
+			router.GET("/", tt.handler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "", w.Header().Get("Vary"))
+			assert.Equal(t, testResponse, w.Body.String())
+		})
+	}
+
+	t.Run("SkipKey before middleware", func(t *testing.T) {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+
+		router := gin.New()
+		router.Use(Disable, Gzip(DefaultCompression))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, testResponse)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, testResponse, w.Body.String())
+	})
+}
+
+func TestGzipExcludedRoutes(t *testing.T) {
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithExcludedRoutes([]string{"/users/:id/avatar"})))
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	}
+	router.GET("/users/:id/avatar", handler)
+	router.GET("/users/:id/profile", handler)
+
+	tests := []struct {
+		path                    string
+		expectedContentEncoding string
+	}{
+		{"/users/1/avatar", ""},
+		{"/users/42/avatar", ""},
+		{"/users/1/profile", "gzip"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", tt.path, nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.path)
+	}
+}
+
+func TestGzipRecovery(t *testing.T) {
+	recovery := gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, _ interface{}) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+	})
+
+	tests := []struct {
+		name                    string
+		middleware              []gin.HandlerFunc
+		expectedContentEncoding string
+	}{
+		// the panic unwinds through the middleware before recovery writes
+		{"recovery before gzip", []gin.HandlerFunc{recovery, Gzip(DefaultCompression)}, ""},
+		{"recovery after gzip", []gin.HandlerFunc{Gzip(DefaultCompression), recovery}, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(tt.middleware...)
+			router.GET("/", func(c *gin.Context) {
+				c.Header("Content-Type", "application/json")
+				c.Status(http.StatusOK)
+				panic("boom")
+			})
+
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+
+			body := w.Body.String()
+			if tt.expectedContentEncoding == "gzip" {
+				gr, err := gzip.NewReader(w.Body)
+				assert.NoError(t, err)
+				raw, _ := io.ReadAll(gr)
+				body = string(raw)
+			} else {
+				assert.Empty(t, w.Header().Get("Vary"))
+			}
+			assert.JSONEq(t, `{"error":"internal"}`, body)
+		})
+	}
+}
+
+func TestGzipExcludedContentTypes(t *testing.T) {
+	tests := []struct {
+		contentType             string
+		options                 []Option
+		expectedContentEncoding string
+	}{
+		{"application/grpc-web+proto", nil, ""},
+		{"application/grpc-web-text", nil, ""},
+		{"application/grpc", nil, ""},
+		{"application/zip", nil, ""},
+		{"image/webp", nil, ""},
+		{"VIDEO/mp4", nil, ""},
+		{"font/woff2", nil, ""},
+		{"application/x-protobuf", nil, "gzip"},
+		{"application/json; charset=utf-8", nil, "gzip"},
+		{"application/zip", []Option{WithExcludedContentTypes([]string{"text/csv"})}, "gzip"},
+		{"text/csv; header=present", []Option{WithExcludedContentTypes([]string{"text/csv"})}, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, tt.options...))
+		router.GET("/", func(c *gin.Context) {
+			c.Data(http.StatusOK, tt.contentType, []byte(testResponse))
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.contentType)
+		if tt.expectedContentEncoding == "" {
+			assert.Equal(t, testResponse, w.Body.String(), tt.contentType)
+		}
+	}
+}
+
+func TestGzipExcludedStatusCodes(t *testing.T) {
+	tests := []struct {
+		status                  int
+		options                 []Option
+		expectedContentEncoding string
+	}{
+		{http.StatusNoContent, nil, ""},
+		{http.StatusNotModified, nil, ""},
+		{http.StatusMultiStatus, nil, "gzip"},
+		{http.StatusNotFound, nil, "gzip"},
+		{http.StatusMultiStatus, []Option{WithExcludedStatusCodes([]int{http.StatusMultiStatus})}, ""},
+	}
+
+	for _, tt := range tests {
+		var errs []error
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Next()
+			for _, err := range c.Errors {
+				errs = append(errs, err)
+			}
+		}, Gzip(DefaultCompression, tt.options...))
+		router.GET("/", func(c *gin.Context) {
+			if bodyAllowedForStatus(tt.status) {
+				c.String(tt.status, testResponse)
+				return
+			}
+			c.Status(tt.status)
+			c.Writer.WriteHeaderNow()
+		})
+		server := httptest.NewServer(router)
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		res, err := server.Client().Transport.RoundTrip(req)
+		if assert.NoError(t, err) {
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+			assert.Equal(t, tt.expectedContentEncoding, res.Header.Get("Content-Encoding"), tt.status)
+		}
+		server.Close()
+		assert.Empty(t, errs, tt.status)
+	}
+}
+
+func bodyAllowedForStatus(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func TestGzipHeadersSetLazily(t *testing.T) {
+	tests := []struct {
+		name                    string
+		handler                 gin.HandlerFunc
+		expectedContentEncoding string
+		expectedVary            []string
+	}{
+		{"handler encoding", func(c *gin.Context) {
+			c.Header("Content-Encoding", "br")
+			c.Data(http.StatusOK, "text/plain", []byte("opaque"))
+		}, "br", nil},
+		{"identity", func(c *gin.Context) {
+			c.Header("Content-Encoding", "identity")
+			c.String(http.StatusOK, testResponse)
+		}, "gzip", []string{"Accept-Encoding"}},
+		{"handler vary", func(c *gin.Context) {
+			c.Header("Vary", "Origin")
+			c.String(http.StatusOK, testResponse)
+		}, "gzip", []string{"Origin", "Accept-Encoding"}},
+		{"no body", func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		}, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression))
+			router.GET("/", tt.handler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.expectedVary, w.Header().Values("Vary"))
+		})
+	}
+}
+
+// plainResponseWriter is an http.ResponseWriter without any optional
+// interfaces such as http.Flusher.
+type plainResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (w *plainResponseWriter) Header() http.Header         { return w.header }
+func (w *plainResponseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *plainResponseWriter) WriteHeader(code int)        { w.code = code }
+
+func TestGzipFlushWithoutFlusher(t *testing.T) {
+	tests := []struct {
+		name             string
+		w                http.ResponseWriter
+		expectedCanFlush bool
+	}{
+		{"recorder", httptest.NewRecorder(), true},
+		{"no flusher", &plainResponseWriter{header: http.Header{}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression))
+			router.GET("/", func(c *gin.Context) {
+				w, ok := c.Writer.(ResponseWriter)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedCanFlush, w.CanFlush())
+
+				c.String(http.StatusOK, testResponse)
+				assert.NotPanics(t, c.Writer.Flush)
+				c.String(http.StatusOK, testResponse)
+			})
+
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Add("Accept-Encoding", "gzip")
+			router.ServeHTTP(tt.w, req)
+
+			var body io.Reader
+			switch w := tt.w.(type) {
+			case *httptest.ResponseRecorder:
+				body = w.Body
+			case *plainResponseWriter:
+				assert.Equal(t, http.StatusOK, w.code)
+				body = &w.body
+			}
+			assert.Equal(t, "gzip", tt.w.Header().Get("Content-Encoding"))
+			gr, err := gzip.NewReader(body)
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(gr)
+			assert.Equal(t, testResponse+testResponse, string(data))
+		})
+	}
+}
+
+func TestGzipContentSniffing(t *testing.T) {
+	tests := []struct {
+		name                    string
+		body                    string
+		options                 []Option
+		expectedContentEncoding string
+	}{
+		{"png", "\x89PNG\r\n\x1a\n....", []Option{WithContentSniffing()}, ""},
+		{"jpeg", "\xff\xd8\xff\xe0....", []Option{WithContentSniffing()}, ""},
+		{"zip", "PK\x03\x04....", []Option{WithContentSniffing()}, ""},
+		{"mp4", "\x00\x00\x00\x18ftypmp42", []Option{WithContentSniffing()}, ""},
+		{"pdf", "%PDF-1.7\n....", []Option{WithContentSniffing()}, ""},
+		{"gzip", "\x1f\x8b\x08\x00....", []Option{WithContentSniffing()}, ""},
+		{"text", testResponse, []Option{WithContentSniffing()}, "gzip"},
+		{"png without sniffing", "\x89PNG\r\n\x1a\n....", nil, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, tt.options...))
+			router.GET("/write", func(c *gin.Context) {
+				c.Data(http.StatusOK, "application/octet-stream", []byte(tt.body))
+			})
+			router.GET("/copy", func(c *gin.Context) {
+				c.Header("Content-Type", "application/octet-stream")
+				_, _ = io.Copy(c.Writer, strings.NewReader(tt.body))
+			})
+
+			for _, path := range []string{"/write", "/copy"} {
+				req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+				req.Header.Add("Accept-Encoding", "gzip")
+
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), path)
+				if tt.expectedContentEncoding == "" {
+					assert.Equal(t, tt.body, w.Body.String(), path)
+				}
+			}
+		})
+	}
+}
+
+func TestGzipCloseNotifyFallback(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(rw, testReverseResponse)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/reverse", func(c *gin.Context) {
+		rp.ServeHTTP(c.Writer, c.Request)
+	})
+	router.GET("/notify", func(c *gin.Context) {
+		select {
+		case <-c.Writer.CloseNotify():
+		case <-time.After(time.Second):
+			t.Error("CloseNotify did not fire when the request context was canceled")
+		}
+	})
+
+	// a plain recorder is not an http.CloseNotifier
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/reverse", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(gr)
+	assert.Equal(t, testReverseResponse, string(body))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", "/notify", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestGzipCloseNotifyDelegation(t *testing.T) {
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/", func(c *gin.Context) {
+		select {
+		case <-c.Writer.CloseNotify():
+			c.Status(499)
+		case <-time.After(time.Second):
+			t.Error("CloseNotify of the underlying writer did not reach the handler")
+		}
+	})
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	w := newCloseNotifyingRecorder()
+	w.closed <- true
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 499, w.Code)
+}
+
+func TestGzipWriterCapabilities(t *testing.T) {
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/", func(c *gin.Context) {
+		w := c.Writer
+		_, isReaderFrom := w.(io.ReaderFrom)
+		_, isStringWriter := w.(io.StringWriter)
+		assert.True(t, isReaderFrom)
+		assert.True(t, isStringWriter)
+
+		// reached through Unwrap
+		rc := http.NewResponseController(w)
+		assert.NoError(t, rc.SetWriteDeadline(time.Now().Add(time.Minute)))
+		assert.NoError(t, rc.EnableFullDuplex())
+
+		c.String(http.StatusOK, testResponse)
+		assert.NoError(t, rc.Flush())
+		c.String(http.StatusOK, testResponse)
+	})
+	router.GET("/hijack", func(c *gin.Context) {
+		// a recorder cannot be hijacked; report it instead of panicking
+		_, _, err := c.Writer.Hijack()
+		assert.ErrorIs(t, err, http.ErrNotSupported)
+		c.String(http.StatusOK, testResponse)
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	res, err := server.Client().Transport.RoundTrip(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	gr, err := gzip.NewReader(res.Body)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(gr)
+	assert.Equal(t, testResponse+testResponse, string(body))
+
+	req, _ = http.NewRequestWithContext(context.Background(), "GET", "/hijack", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+}
+
+func TestGzipResponseController(t *testing.T) {
+	flushed := make(chan struct{})
+	flushErr := make(chan error, 1)
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/stream", func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		c.String(http.StatusOK, testResponse)
+		assert.NoError(t, rc.Flush())
+		<-flushed
+		c.String(http.StatusOK, testResponse)
+	})
+	router.GET("/deadline", func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		assert.NoError(t, rc.SetWriteDeadline(time.Now().Add(-time.Second)))
+		c.String(http.StatusOK, testResponse)
+		// the client retries the failed GET, keep the first result
+		select {
+		case flushErr <- rc.Flush():
+		default:
+		}
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL+"/stream", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	res, err := server.Client().Transport.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		close(flushed)
+		return
+	}
+	defer res.Body.Close()
+	gr, err := gzip.NewReader(res.Body)
+	assert.NoError(t, err)
+	// the first chunk arrives while the handler is still waiting
+	first := make([]byte, len(testResponse))
+	_, err = io.ReadFull(gr, first)
+	close(flushed)
+	assert.NoError(t, err)
+	assert.Equal(t, testResponse, string(first))
+	rest, _ := io.ReadAll(gr)
+	assert.Equal(t, testResponse, string(rest))
+
+	req, _ = http.NewRequestWithContext(context.Background(), "GET", server.URL+"/deadline", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	if res, err := server.Client().Transport.RoundTrip(req); err == nil {
+		res.Body.Close()
+	}
+	assert.Error(t, <-flushErr)
+}
+
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (w *pushRecorder) Push(target string, _ *http.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+func TestGzipPushAndEarlyHints(t *testing.T) {
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/push", func(c *gin.Context) {
+		assert.NoError(t, c.Writer.(http.Pusher).Push("/style.css", nil))
+		c.String(http.StatusOK, testResponse)
+	})
+	router.GET("/hints", func(c *gin.Context) {
+		c.Header("Link", "</style.css>; rel=preload; as=style")
+		c.Status(http.StatusEarlyHints)
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, testResponse)
+	})
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/push", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	w := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router.ServeHTTP(w, req)
+	assert.Equal(t, []string{"/style.css"}, w.pushed)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	var pushErr error
+	router.GET("/nopush", func(c *gin.Context) {
+		pushErr = c.Writer.(http.Pusher).Push("/style.css", nil)
+	})
+	req, _ = http.NewRequestWithContext(context.Background(), "GET", "/nopush", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.ErrorIs(t, pushErr, http.ErrNotSupported)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	var informational []int
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			assert.Empty(t, header.Get("Content-Encoding"))
+			return nil
+		},
+	}
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+	req, _ = http.NewRequestWithContext(ctx, "GET", server.URL+"/hints", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	res, err := server.Client().Transport.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(t, []int{http.StatusEarlyHints}, informational)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	gr, err := gzip.NewReader(res.Body)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(gr)
+	assert.Equal(t, testResponse, string(body))
+}
+
+// decodePrefix returns what the gzip stream in b decodes to so far.
+func decodePrefix(b []byte) string {
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return ""
+	}
+	data, _ := io.ReadAll(gr)
+	return string(data)
+}
+
+func TestGzipFlushPolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          FlushPolicy
+		expectedFlushed string
+	}{
+		{"immediate", FlushImmediate, testResponse},
+		{"buffered", FlushBuffered, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		var flushed string
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, WithFlushPolicy(tt.policy)))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, testResponse)
+			c.Writer.Flush()
+			flushed = decodePrefix(w.Body.Bytes())
+			c.String(http.StatusOK, testResponse)
+		})
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		router.ServeHTTP(w, req)
+
+		assert.True(t, w.Flushed, tt.name)
+		assert.Equal(t, tt.expectedFlushed, flushed, tt.name)
+		assert.Equal(t, testResponse+testResponse, decodePrefix(w.Body.Bytes()), tt.name)
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	events := []string{"data: 1\n\n", "data: 2\n\n", "data: 3\n\n"}
+	w := httptest.NewRecorder()
+	var received []string
+	router := gin.New()
+	// FlushBuffered would keep the events in the compressor, Streamer must not
+	router.Use(Gzip(DefaultCompression, WithFlushPolicy(FlushBuffered)))
+	router.GET("/events", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		i := 0
+		c.Stream(func(_ io.Writer) bool {
+			_, err := io.WriteString(Streamer(c), events[i])
+			assert.NoError(t, err)
+			received = append(received, decodePrefix(w.Body.Bytes()))
+			i++
+			return i < len(events)
+		})
+	})
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/events", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	for i := range events {
+		assert.Equal(t, strings.Join(events[:i+1], ""), received[i])
+	}
+
+	// one gzip member holding every event
+	body := bytes.NewReader(w.Body.Bytes())
+	gr, err := gzip.NewReader(body)
+	assert.NoError(t, err)
+	gr.Multistream(false)
+	data, err := io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(events, ""), string(data))
+	assert.Zero(t, body.Len())
+}
+
+func TestGzipFlushInterval(t *testing.T) {
+	testIdleFlush(t, WithFlushPolicy(FlushInterval(10*time.Millisecond)), 2)
+}
+
+func TestGzipFirstByteTimeout(t *testing.T) {
+	testIdleFlush(t, WithFirstByteTimeout(10*time.Millisecond), 1)
+}
+
+func TestGzipFlushIntervalHandlerState(t *testing.T) {
+	// the flushes must not touch the writer or the headers behind the
+	// handler's back, which -race reports
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithFlushPolicy(FlushInterval(time.Millisecond)),
+		WithFirstByteTimeout(time.Millisecond)))
+	router.GET("/", func(c *gin.Context) {
+		for i := 0; i < 20; i++ {
+			c.Header("X-Size", strconv.Itoa(c.Writer.Size()))
+			c.String(http.StatusOK, testResponse)
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	res, err := server.Client().Transport.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	gr, err := gzip.NewReader(res.Body)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(gr)
+	assert.Equal(t, strings.Repeat(testResponse, 20), string(body))
+}
+
+// testIdleFlush checks that each of the first rounds chunks of a handler that
+// never flushes reaches the client while the handler waits for it, before
+// the handler writes again.
+func testIdleFlush(t *testing.T, option Option, rounds int) {
+	received := make(chan struct{}, rounds)
+	timedOut := make(chan struct{}, rounds)
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, option))
+	router.GET("/", func(c *gin.Context) {
+		for i := 0; i < rounds; i++ {
+			c.String(http.StatusOK, testResponse)
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				timedOut <- struct{}{}
+			}
+		}
+		c.String(http.StatusOK, testResponse)
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	res, err := server.Client().Transport.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	gr, err := gzip.NewReader(res.Body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < rounds; i++ {
+		chunk := make([]byte, len(testResponse))
+		_, err = io.ReadFull(gr, chunk)
+		received <- struct{}{}
+		assert.NoError(t, err)
+		assert.Equal(t, testResponse, string(chunk))
+	}
+	rest, _ := io.ReadAll(gr)
+	assert.Equal(t, testResponse, string(rest))
+	assert.Empty(t, timedOut, "chunks waited for the handler's next write")
+}
+
+func TestWrapHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/text", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, testResponse)
+	})
+	mux.HandleFunc("/created", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, testResponse)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, testResponse)
+	})
+	for path, code := range map[string]int{
+		"/gone": http.StatusNotFound, "/empty": http.StatusNoContent, "/broken": http.StatusInternalServerError,
+	} {
+		code := code
+		mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(code)
+		})
+	}
+	handler := WrapHandler(mux, DefaultCompression, WithExcludedPaths([]string{"/metrics"}))
+
+	tests := []struct {
+		path                    string
+		expectedStatus          int
+		expectedContentEncoding string
+	}{
+		{"/text", http.StatusOK, "gzip"},
+		{"/created", http.StatusCreated, "gzip"},
+		{"/metrics", http.StatusOK, ""},
+		{"/missing", http.StatusNotFound, "gzip"},
+		// bodiless statuses go out as the handler set them
+		{"/gone", http.StatusNotFound, ""},
+		{"/empty", http.StatusNoContent, ""},
+		{"/broken", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", tt.path, nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.path)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.path)
+		if tt.expectedContentEncoding == "" && tt.expectedStatus != http.StatusOK {
+			assert.Empty(t, w.Body.String(), tt.path)
+			continue
+		}
+		if tt.expectedStatus != http.StatusNotFound {
+			body := w.Body.String()
+			if tt.expectedContentEncoding == "gzip" {
+				gr, err := gzip.NewReader(w.Body)
+				assert.NoError(t, err)
+				data, _ := io.ReadAll(gr)
+				body = string(data)
+			}
+			assert.Equal(t, testResponse, body, tt.path)
+		}
+	}
+}
+
+func TestEncodingSourceFromContext(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Encoding", "br")
+		fmt.Fprint(rw, "opaque")
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		expected       string
+		expectedCoding string
+	}{
+		{"/local", "gzip", EncodingSourceLocal, "gzip"},
+		{"/local", "", "", ""},
+		{"/proxy", "gzip", EncodingSourceUpstream, "br"},
+		{"/proxy", "", EncodingSourceUpstream, "br"},
+	}
+
+	for _, tt := range tests {
+		var source, coding, codingInHandler string
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Next()
+			source = EncodingSourceFromContext(c)
+			coding = EncodingFromContext(c)
+		})
+		router.Use(Gzip(DefaultCompression))
+		router.GET("/local", func(c *gin.Context) {
+			c.String(http.StatusOK, testResponse)
+			codingInHandler = EncodingFromContext(c)
+		})
+		router.GET("/proxy", func(c *gin.Context) {
+			rp.ServeHTTP(c.Writer, c.Request)
+		})
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", tt.path, nil)
+		req.Header.Add("Accept-Encoding", tt.acceptEncoding)
+
+		w := newCloseNotifyingRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expected, source, "%s with %q", tt.path, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedCoding, coding, "%s with %q", tt.path, tt.acceptEncoding)
+		if tt.path == "/local" {
+			assert.Equal(t, tt.expectedCoding, codingInHandler, "%s with %q", tt.path, tt.acceptEncoding)
+		}
+	}
+}
+
+func TestGzipNoRoute(t *testing.T) {
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	var released gin.ResponseWriter
+	router.GET("/done", func(c *gin.Context) {
+		released = c.Writer
+		c.String(http.StatusOK, testResponse)
+	})
+
+	// gin writes its 404 page after the middleware returned
+	for _, acceptEncoding := range []string{"gzip", ""} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/unknown", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, acceptEncoding)
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"), acceptEncoding)
+		assert.Equal(t, "404 page not found", w.Body.String(), acceptEncoding)
+	}
+
+	// a writer kept past the response never reaches the pooled compressor
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/done", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	n, err := released.Write([]byte("late"))
+	assert.Zero(t, n)
+	assert.Error(t, err)
+	assert.Equal(t, testResponse, decodePrefix(w.Body.Bytes()))
+}
+
+func TestGzipOrderWarning(t *testing.T) {
+	mode := gin.Mode()
+	defer gin.SetMode(mode)
+	gin.SetMode(gin.DebugMode)
+	var out bytes.Buffer
+	defer func(w io.Writer) { gin.DefaultWriter = w }(gin.DefaultWriter)
+	gin.DefaultWriter = &out
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression))
+	router.GET("/order/logger", gin.LoggerWithWriter(io.Discard), func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+	router.GET("/order/twice", Gzip(BestSpeed), func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+
+	handler, err := NewHandler(DefaultCompression)
+	assert.NoError(t, err)
+	bare := gin.New()
+	bare.GET("/order/handler", handler.Handle, Gzip(BestSpeed), func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+
+	// gzip is the last handler of the NoRoute chain
+	for _, path := range []string{"/order/logger", "/order/twice", "/order/twice", "/order/handler", "/unknown"} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		assert.NotPanics(t, func() { router.ServeHTTP(httptest.NewRecorder(), req) }, path)
+		assert.NotPanics(t, func() { bare.ServeHTTP(httptest.NewRecorder(), req) }, path)
+	}
+
+	assert.NotContains(t, out.String(), "Logger")
+	assert.Equal(t, 1, strings.Count(out.String(), "registered after gzip.Gzip on /order/twice"))
+	assert.Equal(t, 1, strings.Count(out.String(), "registered after gzip.Gzip on /order/handler"))
+}
+
+func TestGzipDecisionBudget(t *testing.T) {
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	tests := []struct {
+		name                    string
+		fn                      func(int, http.Header) bool
+		expectedContentEncoding string
+	}{
+		{"fast", func(int, http.Header) bool { return false }, ""},
+		{"slow", func(int, http.Header) bool {
+			defer wg.Done()
+			<-release
+			return false
+		}, "gzip"},
+	}
+
+	for _, tt := range tests {
+		if tt.name == "slow" {
+			wg.Add(1)
+		}
+		var elapsed time.Duration
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Next()
+			elapsed = c.MustGet(DecisionDurationKey).(time.Duration)
+		}, Gzip(DefaultCompression, WithResponseShouldCompressFn(tt.fn), WithDecisionBudget(20*time.Millisecond)))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, testResponse)
+		})
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.name)
+		assert.Less(t, elapsed, time.Second, tt.name)
+	}
+	close(release)
+	wg.Wait()
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithDecisionBudget(time.Second),
+		WithResponseShouldCompressFn(func(int, http.Header) bool { panic("policy failed") })))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	assert.PanicsWithValue(t, "policy failed", func() { router.ServeHTTP(httptest.NewRecorder(), req) })
+}
+
+func TestGzipTransferEncodingConflict(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte(testResponse))
+	_ = gz.Close()
+
+	tests := []struct {
+		transferEncoding         string
+		contentEncoding          string
+		expectedContentEncoding  string
+		expectedTransferEncoding []string
+	}{
+		{"gzip", "", "gzip", nil},
+		{"X-GZIP, chunked", "", "gzip", []string{"chunked"}},
+		{"gzip", "identity", "gzip", nil},
+		{"gzip", "br", "br, gzip", nil},
+	}
+
+	for _, tt := range tests {
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression))
+		router.GET("/", func(c *gin.Context) {
+			c.Header("Transfer-Encoding", tt.transferEncoding)
+			c.Header("Content-Encoding", tt.contentEncoding)
+			c.Data(http.StatusOK, "text/plain", buf.Bytes())
+		})
+		server := httptest.NewServer(router)
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		res, err := server.Client().Transport.RoundTrip(req)
+		if assert.NoError(t, err, tt.transferEncoding) {
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.Equal(t, tt.expectedContentEncoding, res.Header.Get("Content-Encoding"), tt.transferEncoding)
+			assert.Equal(t, tt.expectedTransferEncoding, res.TransferEncoding, tt.transferEncoding)
+			assert.Equal(t, buf.Bytes(), body, tt.transferEncoding)
+		}
+		server.Close()
+	}
+}
+
+func TestGzipMinLength(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	tests := []struct {
+		name                    string
+		handler                 gin.HandlerFunc
+		expectedContentEncoding string
+		expectedBody            string
+	}{
+		{"short", func(c *gin.Context) {
+			c.String(http.StatusOK, "short")
+		}, "", "short"},
+		{"short writes", func(c *gin.Context) {
+			for i := 0; i < 10; i++ {
+				c.String(http.StatusOK, "0123456789")
+			}
+		}, "gzip", strings.Repeat("0123456789", 10)},
+		{"long", func(c *gin.Context) {
+			c.String(http.StatusOK, long)
+		}, "gzip", long},
+		{"short flushed", func(c *gin.Context) {
+			c.String(http.StatusOK, "short")
+			c.Writer.Flush()
+		}, "gzip", "short"},
+		{"short read", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+			_, _ = io.Copy(c.Writer, strings.NewReader("short"))
+		}, "", "short"},
+		{"nothing", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		}, "", ""},
+		{"short with length", func(c *gin.Context) {
+			c.Header("Content-Length", "5")
+			c.Status(http.StatusOK)
+			_, _ = c.Writer.WriteString("short")
+			c.Writer.Flush()
+		}, "", "short"},
+		{"long with length", func(c *gin.Context) {
+			c.Header("Content-Length", strconv.Itoa(len(long)))
+			c.Status(http.StatusOK)
+			_, _ = c.Writer.WriteString(long)
+		}, "gzip", long},
+	}
+
+	for _, tt := range tests {
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, WithMinLength(50)))
+		router.GET("/", tt.handler)
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.name)
+		if n := w.Header().Get("Content-Length"); n != "" {
+			assert.Equal(t, strconv.Itoa(w.Body.Len()), n, tt.name)
+		}
+		body := w.Body.String()
+		if tt.expectedContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(w.Body)
+			if !assert.NoError(t, err, tt.name) {
+				continue
+			}
+			data, _ := io.ReadAll(gr)
+			body = string(data)
+		}
+		assert.Equal(t, tt.expectedBody, body, tt.name)
+	}
+}
+
+func TestGzipVaryMaintenance(t *testing.T) {
+	tests := []struct {
+		name         string
+		vary         []string
+		body         string
+		panics       bool
+		expectedVary []string
+	}{
+		{"short keeps other values", []string{"Origin"}, "short", false, []string{"Origin"}},
+		{"compressed appends", []string{"Origin"}, strings.Repeat("a", 100), false,
+			[]string{"Origin", "Accept-Encoding"}},
+		{"already listed", []string{"Origin, accept-encoding"}, strings.Repeat("a", 100), false,
+			[]string{"Origin, accept-encoding"}},
+		{"wildcard", []string{"*"}, strings.Repeat("a", 100), false, []string{"*"}},
+		{"panic keeps listed value", []string{"Accept-Encoding"}, strings.Repeat("a", 100), true,
+			[]string{"Accept-Encoding"}},
+	}
+
+	for _, tt := range tests {
+		router := gin.New()
+		router.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, _ interface{}) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}))
+		router.Use(Gzip(DefaultCompression, WithMinLength(50), WithPoolConfig(0, 0, 4096)))
+		router.GET("/", func(c *gin.Context) {
+			for _, v := range tt.vary {
+				c.Writer.Header().Add("Vary", v)
+			}
+			c.Writer.Header().Set("Content-Type", "text/plain")
+			if tt.panics {
+				_, _ = c.Writer.WriteString(tt.body)
+				panic("boom")
+			}
+			c.String(http.StatusOK, tt.body)
+		})
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedVary, w.Header().Values("Vary"), tt.name)
+	}
+}
+
+func TestGzipETagVariant(t *testing.T) {
+	content := strings.Repeat(testResponse, 100)
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithETagVariant()))
+	router.GET("/", func(c *gin.Context) {
+		c.Header("ETag", `W/"v1"`)
+		http.ServeContent(c.Writer, c.Request, "index.txt", time.Time{}, strings.NewReader(content))
+	})
+
+	tests := []struct {
+		name                    string
+		acceptEncoding          string
+		ifNoneMatch             string
+		expectedStatus          int
+		expectedContentEncoding string
+		expectedETag            string
+	}{
+		{"compressed", "gzip", "", http.StatusOK, "gzip", `W/"v1-gzip"`},
+		{"identity", "", "", http.StatusOK, "", `W/"v1"`},
+		{"compressed not modified", "gzip", `W/"v1-gzip"`, http.StatusNotModified, "", `W/"v1-gzip"`},
+		{"identity not modified", "", `W/"v1"`, http.StatusNotModified, "", `W/"v1"`},
+		{"changed", "gzip", `W/"v0-gzip"`, http.StatusOK, "gzip", `W/"v1-gzip"`},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.name)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.name)
+		assert.Equal(t, tt.expectedETag, w.Header().Get("ETag"), tt.name)
+	}
+}
+
+func TestGzipAbandonRestoresHeaders(t *testing.T) {
+	var header http.Header
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		defer func() {
+			if recover() != nil {
+				header = c.Writer.Header().Clone()
+			}
+		}()
+		c.Next()
+	})
+	// the output buffer keeps the headers unsent when the handler panics
+	router.Use(Gzip(DefaultCompression, WithPoolConfig(0, 0, 4096), WithStatsTrailers(),
+		WithETagVariant()))
+	router.GET("/", func(c *gin.Context) {
+		c.Header("Vary", "Origin")
+		c.Header("ETag", `"v1"`)
+		c.Header("Trailer", "X-Checksum")
+		_, _ = c.Writer.WriteString(strings.Repeat("a", 100))
+		panic("boom")
+	})
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Empty(t, header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"Origin"}, header.Values("Vary"))
+	assert.Equal(t, `"v1"`, header.Get("ETag"))
+	assert.Equal(t, []string{"X-Checksum"}, header.Values("Trailer"))
+}
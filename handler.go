@@ -1,87 +1,376 @@
 package gzip
 
 import (
-	"compress/gzip"
-	"fmt"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"io"
 	"net/http"
-	"path/filepath"
-	"strings"
-	"sync"
+	"net/netip"
+	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 type gzipHandler struct {
 	*Options
-	gzPool sync.Pool
+	gzPool      *writerPool
+	http10Pool  *writerPool
+	adaptive    *adaptivePools
+	levelRules  []levelRule
+	headerRules []headerRule
+	internal    []netip.Prefix
+	trusted     []netip.Prefix
+	slots       chan struct{}
+	// dictionaries holds a writer pool for each of Options.Dictionaries, by
+	// SHA-256.
+	dictionaries map[[sha256.Size]byte]*writerPool
 }
 
 func newGzipHandler(level int, options ...Option) *gzipHandler {
+	handler, err := buildGzipHandler(level, options...)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+// buildGzipHandler applies the options and validates the levels before any
+// writer is created, so a bad level never surfaces as a panic mid-request.
+func buildGzipHandler(level int, options ...Option) (*gzipHandler, error) {
+	return compileGzipHandler(level, *DefaultOptions, options...)
+}
+
+// compileGzipHandler applies options to a copy of opts and builds a handler
+// from the result, see buildGzipHandler.
+func compileGzipHandler(level int, opts Options, options ...Option) (*gzipHandler, error) {
 	handler := &gzipHandler{
-		Options: DefaultOptions,
-		gzPool: sync.Pool{
-			New: func() interface{} {
-				gz, err := gzip.NewWriterLevel(io.Discard, level)
-				if err != nil {
-					panic(err)
-				}
-				return gz
-			},
-		},
+		Options: &opts,
 	}
 	for _, setter := range options {
 		setter(handler.Options)
 	}
-	return handler
+	if handler.Level != nil {
+		level = *handler.Level
+	}
+	if handler.ExcludedExtensions != nil {
+		// the set may be shared, e.g. DefaultExcludedExtentions, and changed
+		// while the handler serves requests
+		handler.ExcludedExtensions = handler.ExcludedExtensions.Clone()
+	}
+	if handler.PrecompressedFS == nil && handler.PrecompressedRoot != "" {
+		handler.PrecompressedFS = os.DirFS(handler.PrecompressedRoot)
+	}
+	if minimalBuild && handler.PrecompressedFS != nil {
+		return nil, errors.New("gzip: precompressed files are not available in gzipminimal builds")
+	}
+	if err := handler.compileRegexs(); err != nil {
+		return nil, err
+	}
+	if handler.CaseInsensitiveMatching {
+		handler.foldCase()
+	}
+	if err := validateLevel(level); err != nil {
+		return nil, err
+	}
+	var err error
+	if handler.AdaptiveMaxLevel != 0 {
+		if err := validateAdaptiveLevels(handler.AdaptiveMinLevel, handler.AdaptiveMaxLevel); err != nil {
+			return nil, err
+		}
+		handler.adaptive, err = newAdaptivePools(handler.AdaptiveMinLevel, handler.AdaptiveMaxLevel,
+			handler.newPool, handler.LoadProbe)
+		level = handler.AdaptiveMaxLevel
+	} else {
+		handler.gzPool, err = handler.newPool(level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(handler.LevelRules) > 0 {
+		rules, err := newLevelRules(handler.LevelRules, handler.newPool)
+		if err != nil {
+			return nil, err
+		}
+		handler.levelRules = rules
+	}
+	if handler.headerRules, err = newHeaderRules(handler.RequestHeaderRules); err != nil {
+		return nil, err
+	}
+	if handler.internal, err = newNetworks("internal", handler.SkipInternal); err != nil {
+		return nil, err
+	}
+	if handler.trusted, err = newNetworks("trusted", handler.TrustedNetworks); err != nil {
+		return nil, err
+	}
+	if n := handler.MaxConcurrentCompressions; n > 0 {
+		handler.slots = make(chan struct{}, n)
+	}
+	if handler.dictionaries, err = newDictionaries(handler.Dictionaries, level, handler.PoolConfig); err != nil {
+		return nil, err
+	}
+	if size := handler.HTTP10BufferSize; size > 0 {
+		handler.http10Pool = newWriterPool(level, PoolConfig{
+			MaxWriters: handler.PoolConfig.MaxWriters,
+			BufferSize: size,
+		})
+	}
+	return handler, nil
+}
+
+// newPool returns the writer pool for level, shared through the PoolManager
+// if one is set.
+func (g *gzipHandler) newPool(level int) (*writerPool, error) {
+	if g.PoolManager != nil {
+		return g.PoolManager.pool(level)
+	}
+	return newWriterPool(level, g.PoolConfig), nil
 }
 
 func (g *gzipHandler) Handle(c *gin.Context) {
-	if fn := g.DecompressFn; fn != nil && c.Request.Header.Get("Content-Encoding") == "gzip" {
-		fn(c)
+	checkOrder(c)
+	if err := g.decompress(c); err != nil && c.IsAborted() {
+		return
 	}
 
-	if !g.shouldCompress(c.Request) {
+	reason := g.requestSkipReason(c)
+	if reason == ReasonAcceptEncoding && g.StrictNegotiation &&
+		cachedAcceptEncoding(c.Request.Header.Values("Accept-Encoding")).forbidsIdentity() {
+		g.emit(c, Event{Kind: EventSkipped, Reason: reason})
+		c.AbortWithStatus(http.StatusNotAcceptable)
 		return
 	}
+	if reason == "" && g.PrecompressedFS != nil && g.precompressedRequest(c) && g.servePrecompressed(c) {
+		recordEncodingSource(c, true)
+		return
+	}
+	if reason == "" && !g.acquireSlot() {
+		reason = ReasonConcurrency
+	}
+	if reason != "" {
+		g.emit(c, Event{Kind: EventSkipped, Reason: reason})
+		if reason == ReasonAcceptEncoding && g.Transcode {
+			transcode(c)
+		} else {
+			c.Next()
+		}
+		recordEncodingSource(c, false)
+		return
+	}
+	if g.slots != nil {
+		defer func() { <-g.slots }()
+	}
 
-	gz := g.gzPool.Get().(*gzip.Writer)
-	defer g.gzPool.Put(gz)
-	defer gz.Reset(io.Discard)
-	gz.Reset(c.Writer)
+	g.compress(c, c.Next)
+}
 
-	c.Header("Content-Encoding", "gzip")
-	c.Header("Vary", "Accept-Encoding")
-	c.Writer = &gzipWriter{c.Writer, gz}
+// compress runs next, the rest of the chain or a handler of its own, with
+// c.Writer compressing the response.
+func (g *gzipHandler) compress(c *gin.Context, next func()) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), compressingKey{}, true))
+	gw := g.newWriter(c, c.Writer)
+	gw.etagVariant = g.ETagVariant && stripETagVariants(c.Request.Header)
+	c.Writer = gw
+	completed := false
 	defer func() {
-		if c.Writer.Size() < 0 {
-			// do not write gzip footer when nothing is written to the response body
-			gz.Reset(io.Discard)
+		if !completed {
+			gw.abandon(c)
+			// the panic may have interrupted the compressor mid-write
+			gw.release(true)
+			debugPrintWARNING("panic in handler passed through the middleware, " +
+				"register gin.Recovery after gzip.Gzip to compress error pages")
+			return
 		}
-		gz.Close()
-		c.Header("Content-Length", fmt.Sprint(c.Writer.Size()))
+		compressed, err := gw.finish()
+		// handlers after the middleware, such as gin's 404 page, write to
+		// the response directly
+		c.Writer = gw.ResponseWriter
+		if err != nil {
+			_ = c.Error(err)
+		}
+		recordEncodingSource(c, compressed)
 	}()
-	c.Next()
+	next()
+	completed = true
 }
 
-func (g *gzipHandler) shouldCompress(req *http.Request) bool {
-	if !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") ||
-		strings.Contains(req.Header.Get("Connection"), "Upgrade") ||
-		strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
-		return false
+// newWriter wraps w in a gzipWriter with a compressor from the pool serving
+// the request. The writer must be finished or released.
+func (g *gzipHandler) newWriter(c *gin.Context, w gin.ResponseWriter) *gzipWriter {
+	pool, coding := g.pool(c), "gzip"
+	if dict := g.dictionaryPool(c); dict != nil {
+		pool, coding = dict, "dcz"
+	}
+	if g.adaptive != nil {
+		g.adaptive.inFlight.Add(1)
+	}
+	gz := pool.get()
+	gz.Reset(w)
+	if g.Deterministic && gz.gzip != nil {
+		gz.gzip.Header = gzipHeader{OS: osUnknown}
+	}
+	if g.HeaderOS != nil && gz.gzip != nil {
+		gz.gzip.Header.OS = *g.HeaderOS
 	}
+	return &gzipWriter{
+		ResponseWriter: w,
+		writer:         gz,
+		pool:           pool,
+		coding:         coding,
+		handler:        g,
+		ctx:            c,
+		base:           baseWriter(w),
+		exposeSizes:    g.StatsTrailers && g.trustedRequest(c),
+	}
+}
 
-	extension := filepath.Ext(req.URL.Path)
-	if g.ExcludedExtensions.Contains(extension) {
-		return false
+// trustedRequest reports whether the request may see the internals of the
+// middleware, see WithTrustedNetworks.
+func (g *gzipHandler) trustedRequest(c *gin.Context) bool {
+	return clientFromNetworks(g.trusted, c) || (g.TrustedRequestFn != nil && g.TrustedRequestFn(c))
+}
+
+// finish ends the response once the handler has returned: it writes the gzip
+// footer and trailers, records the statistics and releases the writer. It
+// reports whether the body was compressed.
+func (gw *gzipWriter) finish() (bool, error) {
+	gw.stopTimer()
+	dirty := true
+	defer func() { gw.release(dirty) }()
+	g, gz := gw.handler, gw.writer
+	if !gw.decided {
+		// nothing was written, e.g. a 304 Not Modified
+		gw.notModified()
+	}
+	var held http.Header
+	if !gw.ResponseWriter.Written() {
+		held = gw.holdTrailers()
+	}
+	if gw.pending != nil {
+		gw.complete = true
+		gw.commit()
+	}
+	compressed := !gw.passthrough && (gw.wroteBody || gw.ResponseWriter.Written())
+	if !compressed {
+		// do not write gzip footer when nothing is written to the response body
+		// or when the body was passed through untouched
+		gz.Reset(io.Discard)
+	}
+	err := gz.Close()
+	if f := g.FaultInjection; compressed && f != nil && f.CloseErr != nil {
+		err = f.CloseErr
+	}
+	if gw.decided && !gw.passthrough {
+		gw.setTrailers()
+	}
+	if compressed && !gw.ResponseWriter.Written() && gw.Header().Get("Trailer") == "" {
+		// the whole compressed body is still buffered, so its length is known
+		gw.Header().Set("Content-Length", strconv.Itoa(gz.Buffered()))
 	}
+	if flushErr := gz.FlushBuffer(); err == nil {
+		err = flushErr
+	}
+	dirty = false
+	if held != nil {
+		gw.ResponseWriter.WriteHeaderNow()
+		for name, values := range held {
+			gw.Header()[name] = values
+		}
+	}
+	if compressed && g.Stats {
+		recordCompressed(gw.ctx.FullPath(), gw.originalSize, gw.ResponseWriter.Size())
+	}
+	if compressed && g.SizeObserver != nil {
+		g.SizeObserver(gw.ctx.FullPath(), gw.originalSize, gw.ResponseWriter.Size())
+	}
+	if size := gw.Size(); size >= 0 {
+		gw.Header().Set("Content-Length", strconv.Itoa(size))
+	}
+	return compressed, err
+}
 
-	if g.ExcludedPaths.Contains(req.URL.Path) {
-		return false
+// release returns the compressor to its pool, or drops it when dirty, i.e.
+// when a panic may have left it in an unknown state, so later responses never
+// inherit it. It is safe to call twice.
+func (gw *gzipWriter) release(dirty bool) {
+	if gw.pool == nil {
+		return
 	}
-	if g.ExcludedPathesRegexs.Contains(req.URL.Path) {
-		return false
+	gw.stopTimer()
+	if dirty {
+		gw.pool.discard(gw.writer)
+	} else {
+		gw.writer.Reset(io.Discard)
+		gw.pool.put(gw.writer)
 	}
+	gw.pool = nil
+	gw.writer = nil
+	if a := gw.handler.adaptive; a != nil {
+		a.inFlight.Add(-1)
+	}
+}
+
+// decompress decodes the request body with DecompressFn, and its parts with
+// Options.MultipartDecompression. Failures of the former are reported
+// through decompressError or DecompressFn itself, and returned.
+func (g *gzipHandler) decompress(c *gin.Context) error {
+	if err := g.decompressBody(c); err != nil {
+		return err
+	}
+	if g.MultipartDecompression {
+		g.decompressParts(c)
+	}
+	return nil
+}
+
+// decompressBody runs DecompressFn on a gzip encoded request body.
+func (g *gzipHandler) decompressBody(c *gin.Context) error {
+	fn := g.DecompressFn
+	if fn == nil {
+		return nil
+	}
+	gzipped, err := requestGzipEncoded(c.Request)
+	if err != nil {
+		g.decompressError(c, err)
+		return err
+	}
+	if !gzipped || (g.DecompressShouldFn != nil && !g.DecompressShouldFn(c)) {
+		return nil
+	}
+	n := len(c.Errors)
+	c.Set(optionsKey, g.Options)
+	fn(c)
+	if len(c.Errors) > n {
+		return c.Errors[n].Err
+	}
+	return nil
+}
+
+// pool returns the writer pool serving the request.
+func (g *gzipHandler) pool(c *gin.Context) *writerPool {
+	if g.http10Pool != nil && !c.Request.ProtoAtLeast(1, 1) {
+		return g.http10Pool
+	}
+	if pool := g.levelRulePool(c); pool != nil {
+		return pool
+	}
+	if g.adaptive != nil {
+		return g.adaptive.pool()
+	}
+	return g.gzPool
+}
 
-	return true
+// acquireSlot takes one of the slots limiting concurrent compressions without
+// waiting. It reports false when all slots are taken.
+func (g *gzipHandler) acquireSlot() bool {
+	if g.slots == nil {
+		return true
+	}
+	select {
+	case g.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
 }
@@ -4,12 +4,33 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -99,3 +120,2104 @@ func TestHandleDecompressGzip(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "ok", w.Body.String())
 }
+
+func TestHandleDecompressTransferEncoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name             string
+		transferEncoding []string
+		header           http.Header
+		expectedBody     string
+	}{
+		{"field", []string{"gzip"}, http.Header{}, `"" Gzip Test Response`},
+		{"header", nil, http.Header{"Transfer-Encoding": {"gzip, chunked"}}, `"" Gzip Test Response`},
+		{"chunked content", []string{"chunked"}, http.Header{"Content-Encoding": {"gzip"}}, `"" Gzip Test Response`},
+		{"stacked", []string{"gzip"}, http.Header{"Content-Encoding": {"gzip"}}, `"" raw`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := gzipBytes("Gzip Test Response")
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, WithDecompressFn(DefaultDecompressHandle)))
+			router.POST("/", func(c *gin.Context) {
+				data, _ := c.GetRawData()
+				if bytes.Equal(data, body) {
+					data = []byte("raw")
+				}
+				c.String(http.StatusOK, "%q %s", c.GetHeader("Transfer-Encoding"), data)
+			})
+
+			req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", bytes.NewReader(body))
+			req.TransferEncoding = tt.transferEncoding
+			req.Header = tt.header
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandleDecompressContentEncodingParsing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		values         []string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"canonical", []string{"gzip"}, http.StatusOK, "Gzip Test Response"},
+		{"odd casing and empty element", []string{"GZIP ,"}, http.StatusOK, "Gzip Test Response"},
+		{"surrounding whitespace", []string{" \tgzip\t"}, http.StatusOK, "Gzip Test Response"},
+		{"x-gzip", []string{"x-gzip"}, http.StatusOK, "Gzip Test Response"},
+		{"stacked codings", []string{"gzip", "gzip"}, http.StatusOK, "raw"},
+		{"control character", []string{"gzip\x00"}, http.StatusBadRequest, ""},
+		{"inner whitespace", []string{"gz ip"}, http.StatusBadRequest, ""},
+		{"line break", []string{"gzip\r\nX-Injected: 1"}, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			gz := gzip.NewWriter(buf)
+			_, _ = gz.Write([]byte("Gzip Test Response"))
+			gz.Close()
+
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression, WithDecompressFn(DefaultDecompressHandle)))
+			router.POST("/", func(c *gin.Context) {
+				data, _ := c.GetRawData()
+				if bytes.Equal(data, buf.Bytes()) {
+					data = []byte("raw")
+				}
+				c.String(http.StatusOK, string(data))
+			})
+
+			req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", bytes.NewReader(buf.Bytes()))
+			req.Header["Content-Encoding"] = tt.values
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandleGzipWithPoolConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression, WithPoolConfig(2, 4, 64))
+	assert.Len(t, handler.gzPool.idle, 2)
+
+	router := gin.New()
+	router.Use(handler.Handle)
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat("Gzip Test Response", 100))
+	})
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+
+		gr, err := gzip.NewReader(w.Body)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(gr)
+		assert.Equal(t, strings.Repeat("Gzip Test Response", 100), string(body))
+	}
+	assert.Len(t, handler.gzPool.idle, 2)
+}
+
+func TestWriterPoolMaxWriters(t *testing.T) {
+	pool := newWriterPool(DefaultCompression, PoolConfig{InitialSize: 5, MaxWriters: 1})
+	assert.Len(t, pool.idle, 1)
+
+	w1 := pool.get()
+	w2 := pool.get()
+	assert.NotSame(t, w1, w2)
+	pool.put(w1)
+	pool.put(w2)
+	assert.Len(t, pool.idle, 1)
+	w3 := pool.get()
+	assert.Same(t, w1, w3)
+	pool.put(w3)
+}
+
+func TestPoolManager(t *testing.T) {
+	m := NewPoolManager(PoolConfig{MaxWriters: 2})
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithPoolManager(m), WithLevelRules(map[string]int{"/fast": BestSpeed})))
+	router.GET("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, testResponse)
+	})
+	wrapped := WrapHandler(mux, DefaultCompression, WithPoolManager(m))
+
+	get := func(h http.Handler, path string) {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), path)
+	}
+	get(router, "/")
+	get(router, "/fast")
+	get(wrapped, "/")
+
+	// both middlewares share the pool of the default level
+	assert.Len(t, m.pools, 2)
+	defaultPool := m.pools[poolKey{"gzip", DefaultCompression}]
+	assert.Len(t, defaultPool.idle, 1)
+	assert.Len(t, m.pools[poolKey{"gzip", BestSpeed}].idle, 1)
+
+	m.Reset()
+	assert.Empty(t, defaultPool.idle)
+	get(wrapped, "/")
+	assert.Len(t, defaultPool.idle, 1)
+
+	m.Close()
+	assert.Empty(t, defaultPool.idle)
+	get(router, "/")
+	assert.Empty(t, defaultPool.idle)
+	_, err := New(DefaultCompression, WithPoolManager(m))
+	assert.ErrorIs(t, err, ErrPoolManagerClosed)
+}
+
+func TestWriterPoolDoublePut(t *testing.T) {
+	pool := newWriterPool(DefaultCompression, PoolConfig{MaxWriters: 2})
+	w := pool.get()
+	pool.put(w)
+	if invariantPanics {
+		assert.Panics(t, func() { pool.put(w) })
+	} else {
+		pool.put(w)
+	}
+	assert.Len(t, pool.idle, 1)
+}
+
+func TestAdaptiveLevel(t *testing.T) {
+	var load float64
+	handler := newGzipHandler(DefaultCompression,
+		WithAdaptiveLevel(BestSpeed, 5),
+		WithLoadProbe(func() float64 { return load }),
+	)
+
+	tests := []struct {
+		load          float64
+		expectedLevel int
+	}{
+		{0, 5},
+		{-1, 5},
+		{0.5, 3},
+		{0.9, 1},
+		{1, BestSpeed},
+		{4, BestSpeed},
+	}
+	for _, tt := range tests {
+		load = tt.load
+		assert.Equal(t, tt.expectedLevel, handler.adaptive.pool().level, "load %v", tt.load)
+	}
+
+	assert.Panics(t, func() {
+		newGzipHandler(DefaultCompression, WithAdaptiveLevel(5, 1))
+	})
+}
+
+func TestAdaptiveLevelConcurrencyLoad(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression, WithAdaptiveLevel(BestSpeed, BestCompression))
+	assert.Equal(t, BestCompression, handler.adaptive.pool().level)
+
+	handler.adaptive.inFlight.Add(int64(runtime.GOMAXPROCS(0)))
+	assert.Equal(t, BestSpeed, handler.adaptive.pool().level)
+	handler.adaptive.inFlight.Store(0)
+
+	router := gin.New()
+	router.Use(handler.Handle)
+	router.GET("/", func(c *gin.Context) {
+		assert.Equal(t, int64(1), handler.adaptive.inFlight.Load())
+		c.String(http.StatusOK, "Gzip Test Response")
+	})
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, int64(0), handler.adaptive.inFlight.Load())
+}
+
+func TestMaxConcurrentCompressions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithMaxConcurrentCompressions(1)))
+	router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "Gzip Test Response")
+	})
+	router.GET("/fast", func(c *gin.Context) {
+		c.String(http.StatusOK, "Gzip Test Response")
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	slow := make(chan *httptest.ResponseRecorder)
+	go func() { slow <- serve("/slow") }()
+	<-started
+
+	// the only slot is held by /slow, so /fast is not compressed
+	w := serve("/fast")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Gzip Test Response", w.Body.String())
+
+	close(release)
+	assert.Equal(t, "gzip", (<-slow).Header().Get("Content-Encoding"))
+	assert.Equal(t, "gzip", serve("/fast").Header().Get("Content-Encoding"))
+}
+
+func TestNewWithConfig(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds compile out regular expressions")
+	}
+	gin.SetMode(gin.TestMode)
+
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+		"level": 9,
+		"excluded_paths": ["/metrics"],
+		"excluded_paths_regexs": ["^/reports/\\d+$"],
+		"decompress": true,
+		"pool": {"max_writers": 4}
+	}`), &cfg)
+	assert.NoError(t, err)
+
+	handler, err := NewWithConfig(cfg)
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.Use(handler)
+	router.GET("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, "Gzip Test Response")
+	})
+
+	for path, expected := range map[string]string{"/": "gzip", "/metrics": "", "/reports/42": "", "/a.png": ""} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Header().Get("Content-Encoding"), path)
+	}
+}
+
+func TestNewWithConfigValidation(t *testing.T) {
+	level := 12
+	_, err := NewWithConfig(Config{
+		Level:               &level,
+		ExcludedPathsRegexs: []string{"(unclosed"},
+		AdaptiveMinLevel:    7,
+		AdaptiveMaxLevel:    3,
+		SkipHTTP10:          true,
+		HTTP10BufferSize:    1024,
+		RejectMultistream:   true,
+		Pool:                PoolConfig{MaxWriters: -1},
+		RequestHeaderRules:  []RequestHeaderRule{{Name: "User-Agent", Value: "[a-"}},
+	})
+	assert.Error(t, err)
+	for _, msg := range []string{
+		"invalid compression level 12",
+		"excluded path regex",
+		"invalid adaptive level range 7-3",
+		"mutually exclusive",
+		"reject_multistream requires decompress",
+		"pool.max_writers must not be negative",
+		"request header rule for User-Agent",
+	} {
+		assert.ErrorContains(t, err, msg)
+	}
+
+	_, err = NewWithConfig(Config{})
+	assert.NoError(t, err)
+}
+
+func TestNew(t *testing.T) {
+	for _, level := range []int{HuffmanOnly, NoCompression, DefaultCompression, BestSpeed, BestCompression} {
+		handler, err := New(level)
+		assert.NoError(t, err, "level %d", level)
+		assert.NotNil(t, handler)
+	}
+
+	for _, level := range []int{-3, 10, 42} {
+		_, err := New(level)
+		assert.ErrorContains(t, err, "invalid compression level")
+		assert.PanicsWithError(t, err.Error(), func() { Gzip(level) })
+	}
+
+	_, err := New(DefaultCompression, WithAdaptiveLevel(5, 1))
+	assert.ErrorContains(t, err, "invalid adaptive level range 5-1")
+}
+
+func TestDefaultExcludedExtentionsChanged(t *testing.T) {
+	newRouter := func() *gin.Engine {
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression))
+		router.GET("/*path", func(c *gin.Context) {
+			c.String(http.StatusOK, "this is a HTML!")
+		})
+		return router
+	}
+	encoding := func(router *gin.Engine) string {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/index.html", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Get("Content-Encoding")
+	}
+
+	before := newRouter()
+	DefaultExcludedExtentions.Add(".html")
+	defer DefaultExcludedExtentions.Remove(".html")
+	assert.Equal(t, "gzip", encoding(before))
+	assert.Equal(t, "", encoding(newRouter()))
+}
+
+func TestEventHook(t *testing.T) {
+	var events []Event
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression,
+		WithDecompressFn(DefaultDecompressHandle),
+		WithEventHook(func(_ *gin.Context, e Event) { events = append(events, e) }),
+	))
+	router.Any("/", func(c *gin.Context) {
+		_, _ = c.GetRawData()
+		if c.Query("status") != "" {
+			c.Status(http.StatusNoContent)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		c.String(http.StatusOK, "Gzip Test Response")
+	})
+
+	var corrupt bytes.Buffer
+	gz := gzip.NewWriter(&corrupt)
+	_, _ = gz.Write([]byte(strings.Repeat("Gzip Test Response ", 100)))
+	_ = gz.Close()
+	corrupt.Truncate(corrupt.Len() / 2)
+
+	tests := []struct {
+		target   string
+		header   http.Header
+		body     []byte
+		expected []Event
+	}{
+		{"/", http.Header{"Accept-Encoding": {"gzip"}}, nil, nil},
+		{"/", http.Header{}, nil, []Event{{Kind: EventSkipped, Reason: ReasonAcceptEncoding}}},
+		{"/?status=1", http.Header{"Accept-Encoding": {"gzip"}}, nil, []Event{{Kind: EventSkipped, Reason: ReasonStatus}}},
+		{"/", http.Header{"Content-Encoding": {"gzip\x00"}}, nil, []Event{
+			{Kind: EventDecompressFailed, Err: ErrInvalidContentEncoding},
+		}},
+	}
+	for _, tt := range tests {
+		events = nil
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", tt.target, bytes.NewReader(tt.body))
+		req.Header = tt.header
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, tt.expected, events, "%s %v", tt.target, tt.header)
+	}
+
+	events = nil
+	req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", &corrupt)
+	req.Header.Set("Content-Encoding", "gzip")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, Event{Kind: EventSkipped, Reason: ReasonAcceptEncoding}, events[0])
+		assert.Equal(t, EventDecompressFailed, events[1].Kind)
+		assert.ErrorIs(t, events[1].Err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecompressErrorHandler(t *testing.T) {
+	plain := strings.Repeat("not gzip ", 1000)
+	tests := []struct {
+		fallback        bool
+		contentEncoding string
+		expectedStatus  int
+		expectedBody    string
+		expectedErr     error
+	}{
+		{false, "gzip", http.StatusUnprocessableEntity, `{"error":"invalid body encoding"}`, nil},
+		{true, "gzip", http.StatusOK, plain, nil},
+		{false, "gzip\x00", http.StatusUnprocessableEntity, `{"error":"invalid body encoding"}`, ErrInvalidContentEncoding},
+		{true, "gzip\x00", http.StatusOK, plain, ErrInvalidContentEncoding},
+	}
+
+	for _, tt := range tests {
+		var handled error
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression,
+			WithDecompressFn(DefaultDecompressHandle),
+			WithDecompressErrorHandler(func(c *gin.Context, err error) {
+				handled = err
+				if !tt.fallback {
+					c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid body encoding"})
+				}
+			}),
+		))
+		router.POST("/", func(c *gin.Context) {
+			data, err := c.GetRawData()
+			assert.NoError(t, err)
+			c.String(http.StatusOK, string(data))
+		})
+
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", strings.NewReader(plain))
+		req.Header.Set("Content-Encoding", tt.contentEncoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, "%q fallback %v", tt.contentEncoding, tt.fallback)
+		assert.Equal(t, tt.expectedBody, w.Body.String(), "%q fallback %v", tt.contentEncoding, tt.fallback)
+		if assert.Error(t, handled) && tt.expectedErr != nil {
+			assert.ErrorIs(t, handled, tt.expectedErr)
+		}
+	}
+}
+
+func TestLenientDecompression(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte("compressed body"))
+	_ = gz.Close()
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithDecompressFn(DefaultDecompressHandle), WithLenientDecompression()))
+	router.POST("/", func(c *gin.Context) {
+		data, err := c.GetRawData()
+		assert.NoError(t, err)
+		c.String(http.StatusOK, "%q %q", c.GetHeader("Content-Encoding"), data)
+	})
+
+	for body, expected := range map[string]string{
+		buf.String(): `"" "compressed body"`,
+		"plain body": `"" "plain body"`,
+		"\x1f":       `"" "\x1f"`,
+		"":           `"" ""`,
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, body)
+		assert.Equal(t, expected, w.Body.String(), body)
+	}
+}
+
+func TestHandleGzipStaticSiteMode(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds do not serve static files")
+	}
+	gin.SetMode(gin.TestMode)
+
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "app.js.gz"), gzipBytes("precompressed"), 0o600))
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "api"), 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "api", "orders.gz"), gzipBytes("precompressed"), 0o600))
+	asset := strings.Repeat("console.log(1);\n", 100)
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, StaticSiteMode(root)))
+	// registered routes always run their handler, whatever the directory holds
+	router.Any("/api/orders", func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.Method)
+	})
+	router.NoRoute(func(c *gin.Context) {
+		switch c.Request.URL.Path {
+		case "/small.js":
+			c.String(http.StatusOK, "tiny")
+		case "/logo.webp":
+			c.Data(http.StatusOK, "image/webp", []byte(asset))
+		default:
+			c.Header("ETag", `"v1"`)
+			c.String(http.StatusOK, asset)
+		}
+	})
+
+	tests := []struct {
+		method                  string
+		path                    string
+		expectedContentEncoding string
+		expectedETag            string
+		expectedBody            string
+	}{
+		{"GET", "/app.js", "gzip", "", "precompressed"},
+		{"GET", "/other.js", "gzip", `"v1-gzip"`, asset},
+		{"GET", "/small.js", "", "", "tiny"},
+		{"GET", "/logo.webp", "", "", asset},
+		{"GET", "/../app.js", "gzip", "", "precompressed"},
+		{"POST", "/app.js", "gzip", `"v1-gzip"`, asset},
+		{"GET", "/api/orders", "", "", "GET"},
+		{"POST", "/api/orders", "", "", "POST"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), tt.method, tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.path)
+		assert.Equal(t, tt.expectedETag, w.Header().Get("ETag"), tt.path)
+		body := w.Body.String()
+		if tt.expectedContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(w.Body)
+			if !assert.NoError(t, err, tt.path) {
+				continue
+			}
+			data, _ := io.ReadAll(gr)
+			body = string(data)
+		}
+		assert.Equal(t, tt.expectedBody, body, tt.path)
+	}
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "text/javascript; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+}
+
+func TestStatic(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds do not serve static files")
+	}
+	gin.SetMode(gin.TestMode)
+
+	root := t.TempDir()
+	page := strings.Repeat("<p>hello</p>\n", 100)
+	logo := strings.Repeat("\x89PNG", 100)
+	for name, content := range map[string]string{
+		"app.js":          "console.log(1);",
+		"app.js.gz":       string(gzipBytes("precompressed")),
+		"page.html":       page,
+		"logo.png":        logo,
+		"docs/index.html": page,
+		"empty/.keep":     "",
+	} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o700))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o600))
+	}
+
+	router := gin.New()
+	Static(router.Group("/assets"), "/static", root)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	client := &http.Client{
+		Transport: &http.Transport{DisableCompression: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	tests := []struct {
+		path                    string
+		acceptEncoding          string
+		expectedCode            int
+		expectedContentEncoding string
+		expectedBody            string
+	}{
+		{"/assets/static/app.js", "gzip", http.StatusOK, "gzip", "precompressed"},
+		{"/assets/static/app.js", "", http.StatusOK, "", "console.log(1);"},
+		{"/assets/static/page.html", "gzip", http.StatusOK, "gzip", page},
+		{"/assets/static/page.html", "", http.StatusOK, "", page},
+		{"/assets/static/logo.png", "gzip", http.StatusOK, "", logo},
+		{"/assets/static/docs/", "gzip", http.StatusOK, "gzip", page},
+		{"/assets/static/docs", "gzip", http.StatusMovedPermanently, "", ""},
+		{"/assets/static/empty/", "gzip", http.StatusNotFound, "", ""},
+		{"/assets/static/missing.js", "gzip", http.StatusNotFound, "", ""},
+		{"/assets/static/../../etc/passwd", "gzip", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL+tt.path, nil)
+		req.URL.Path = tt.path
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		res, err := client.Do(req)
+		if !assert.NoError(t, err, tt.path) {
+			continue
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		assert.NoError(t, err, tt.path)
+
+		assert.Equal(t, tt.expectedCode, res.StatusCode, tt.path)
+		assert.Equal(t, tt.expectedContentEncoding, res.Header.Get("Content-Encoding"), tt.path)
+		if tt.expectedContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(bytes.NewReader(body))
+			if !assert.NoError(t, err, tt.path) {
+				continue
+			}
+			body, _ = io.ReadAll(gr)
+		}
+		if tt.expectedCode == http.StatusOK {
+			assert.Equal(t, tt.expectedBody, string(body), tt.path)
+		}
+	}
+}
+
+// noSeekFS hides the Seek method of the files of its fs.FS.
+type noSeekFS struct{ fs.FS }
+
+func (fsys noSeekFS) Open(name string) (fs.File, error) {
+	f, err := fsys.FS.Open(name)
+	return struct{ fs.File }{f}, err
+}
+
+func TestFileSystems(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds do not serve static files")
+	}
+	gin.SetMode(gin.TestMode)
+
+	page := strings.Repeat("<p>hello</p>\n", 100)
+	fsys := fstest.MapFS{
+		"app.js":          {Data: []byte("console.log(1);")},
+		"app.js.gz":       {Data: gzipBytes("precompressed")},
+		"docs/index.html": {Data: []byte(page)},
+		"site/app.js.gz":  {Data: gzipBytes("precompressed")},
+		"gzip.json":       {Data: []byte(`{"excluded_paths": ["/metrics"]}`)},
+	}
+	h, err := NewFromConfigFS(fsys, "gzip.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = NewFromConfigFS(fsys, "missing.yaml")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	router := gin.New()
+	StaticFromFS(router.Group("/"), "/static", noSeekFS{fsys})
+	site := router.Group("/site", Gzip(DefaultCompression, StaticSiteModeFS(noSeekFS{fsys}),
+		WithPrecompressedPrefix("/site/")))
+	site.GET("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, page)
+	})
+	router.GET("/metrics", h.Handle, func(c *gin.Context) {
+		c.String(http.StatusOK, page)
+	})
+
+	for _, tt := range []struct {
+		path                    string
+		expectedContentEncoding string
+		expectedBody            string
+	}{
+		{"/static/app.js", "gzip", "precompressed"},
+		{"/static/docs/", "gzip", page},
+		{"/site/app.js", "gzip", "precompressed"},
+		{"/site/other.js", "gzip", page},
+		{"/metrics", "", page},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, tt.path)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.path)
+		body := w.Body.String()
+		if tt.expectedContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(w.Body)
+			if !assert.NoError(t, err, tt.path) {
+				continue
+			}
+			data, _ := io.ReadAll(gr)
+			body = string(data)
+		}
+		assert.Equal(t, tt.expectedBody, body, tt.path)
+	}
+}
+
+func TestStaticFS(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds do not serve static files")
+	}
+	gin.SetMode(gin.TestMode)
+
+	page := strings.Repeat("<p>hello</p>\n", 100)
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html": page,
+		"app.js":     strings.Repeat("console.log(1);\n", 100),
+		"app.js.br":  "brotli",
+		"logo.png":   strings.Repeat("\x89PNG", 100),
+		"tiny.css":   "a{}",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o600))
+	}
+	assert.NoError(t, PrecompressDir(root, BestCompression))
+	_, err := os.Stat(filepath.Join(root, "index.html.gz"))
+	assert.NoError(t, err)
+	for _, name := range []string{"logo.png.gz", "tiny.css.gz", "app.js.br.gz"} {
+		_, err := os.Stat(filepath.Join(root, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+
+	router := gin.New()
+	router.GET("/assets/*filepath", StaticFS(os.DirFS(root), WithMinLength(16)))
+
+	tests := []struct {
+		path                    string
+		acceptEncoding          string
+		expectedCode            int
+		expectedContentEncoding string
+	}{
+		{"/assets/", "gzip", http.StatusOK, "gzip"},
+		{"/assets/index.html", "", http.StatusOK, ""},
+		{"/assets/app.js", "gzip, br", http.StatusOK, "br"},
+		{"/assets/app.js", "gzip", http.StatusOK, "gzip"},
+		{"/assets/app.js", "br;q=0, gzip", http.StatusOK, "gzip"},
+		{"/assets/logo.png", "gzip", http.StatusOK, ""},
+		{"/assets/tiny.css", "gzip", http.StatusOK, ""},
+		{"/assets/missing.js", "gzip", http.StatusNotFound, ""},
+		{"/assets/app.js.br", "br", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedCode, w.Code, tt.path)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), "%s %s", tt.path, tt.acceptEncoding)
+		if tt.expectedCode != http.StatusOK {
+			continue
+		}
+		assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"), tt.path)
+		switch tt.expectedContentEncoding {
+		case "gzip":
+			assert.True(t, strings.HasSuffix(w.Header().Get("ETag"), `-gzip"`), tt.path)
+			gr, err := gzip.NewReader(w.Body)
+			if assert.NoError(t, err, tt.path) {
+				data, _ := io.ReadAll(gr)
+				assert.NotEmpty(t, data, tt.path)
+			}
+		case "br":
+			assert.Equal(t, "brotli", w.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/assets/index.html", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	req, _ = http.NewRequestWithContext(context.Background(), "GET", "/assets/index.html", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestPrecompressDir(t *testing.T) {
+	root := t.TempDir()
+	page := filepath.Join(root, "index.html")
+	sibling := page + ".gz"
+	readSibling := func() string {
+		f, err := os.Open(sibling)
+		if err != nil {
+			return err.Error()
+		}
+		defer f.Close()
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err.Error()
+		}
+		data, _ := io.ReadAll(gr)
+		return string(data)
+	}
+	content := strings.Repeat("<p>hello</p>\n", 100)
+	assert.NoError(t, os.WriteFile(page, []byte(content), 0o600))
+	archive := filepath.Join(root, "dump.sql.gz")
+	assert.NoError(t, os.WriteFile(archive, []byte("not ours"), 0o600))
+
+	assert.NoError(t, PrecompressDir(root, BestCompression))
+	assert.Equal(t, content, readSibling())
+	info, _ := os.Stat(page)
+	gzInfo, _ := os.Stat(sibling)
+	assert.Equal(t, info.ModTime(), gzInfo.ModTime())
+	assert.Equal(t, os.FileMode(0o644), gzInfo.Mode().Perm())
+
+	// an up to date sibling is kept, a rebuild rewrites it
+	assert.NoError(t, os.WriteFile(sibling, []byte("stale"), 0o600))
+	assert.NoError(t, os.Chtimes(sibling, info.ModTime(), info.ModTime()))
+	assert.NoError(t, PrecompressDir(root, BestCompression))
+	assert.NotEqual(t, content, readSibling())
+	assert.NoError(t, RebuildPrecompressedDir(root, BestCompression))
+	assert.Equal(t, content, readSibling())
+
+	// a changed file gets a new sibling
+	content = strings.Repeat("<p>bye</p>\n", 100)
+	assert.NoError(t, os.WriteFile(page, []byte(content), 0o600))
+	later := info.ModTime().Add(time.Second)
+	assert.NoError(t, os.Chtimes(page, later, later))
+	assert.NoError(t, PrecompressDir(root, BestCompression))
+	assert.Equal(t, content, readSibling())
+	assert.NoError(t, PrecompressDirWithOptions(root, PrecompressOptions{Level: BestSpeed, Rebuild: true, Sync: true}))
+	assert.Equal(t, content, readSibling())
+
+	// a file that is gone or no longer shrinks loses its sibling, other gzip
+	// files are left alone
+	assert.NoError(t, os.WriteFile(page, []byte("<p>"), 0o600))
+	assert.NoError(t, PrecompressDir(root, BestCompression))
+	_, err := os.Stat(sibling)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, os.WriteFile(page, []byte(content), 0o600))
+	assert.NoError(t, PrecompressDir(root, BestCompression))
+	assert.NoError(t, os.Remove(page))
+	assert.NoError(t, PrecompressDir(root, BestCompression))
+	_, err = os.Stat(sibling)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(archive)
+	assert.NoError(t, err)
+
+	entries, _ := os.ReadDir(root)
+	assert.Len(t, entries, 1)
+	assert.Error(t, PrecompressDir(root, 42))
+}
+
+func TestHandleGzipAPIMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression, APIMode(), WithMaxDecompressedSize(64))
+	assert.Equal(t, BestSpeed, handler.gzPool.level)
+
+	large := strings.Repeat("x", 2048)
+	router := gin.New()
+	router.Use(handler.Handle)
+	router.GET("/:kind", func(c *gin.Context) {
+		switch c.Param("kind") {
+		case "json":
+			c.JSON(http.StatusOK, gin.H{"data": large})
+		case "small":
+			c.JSON(http.StatusOK, gin.H{"data": "small"})
+		case "binary":
+			c.Data(http.StatusOK, "application/octet-stream", []byte(large))
+		}
+	})
+	router.POST("/upload", func(c *gin.Context) {
+		_, err := c.GetRawData()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	for path, expected := range map[string]string{"/json": "gzip", "/small": "", "/binary": ""} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Header().Get("Content-Encoding"), path)
+	}
+
+	for body, expected := range map[string]int{
+		"small":                 http.StatusNoContent,
+		strings.Repeat("x", 65): http.StatusRequestEntityTooLarge,
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", "/upload", bytes.NewReader(gzipBytes(body)))
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Code, len(body))
+	}
+}
+
+func TestHandleGzipPresets(t *testing.T) {
+	large := strings.Repeat("x", 2048)
+	tests := []struct {
+		name          string
+		preset        Option
+		expectedLevel int
+		expected      map[string]string
+	}{
+		{"api", PresetAPI(), BestSpeed, map[string]string{
+			"application/json": "gzip", "text/css": "gzip", "image/svg+xml": "", "application/octet-stream": "",
+		}},
+		{"static assets", PresetStaticAssets(), BestCompression, map[string]string{
+			"application/json": "gzip", "text/css": "gzip", "image/svg+xml": "gzip", "application/octet-stream": "",
+		}},
+		{"proxy", PresetProxy(), BestSpeed, map[string]string{
+			"application/json": "gzip", "text/css": "gzip", "image/svg+xml": "gzip", "application/octet-stream": "gzip",
+		}},
+	}
+	for _, tt := range tests {
+		handler := newGzipHandler(DefaultCompression, tt.preset)
+		assert.Equal(t, tt.expectedLevel, handler.gzPool.level, tt.name)
+
+		router := gin.New()
+		router.Use(handler.Handle)
+		router.GET("/", func(c *gin.Context) {
+			body := large
+			if c.Query("small") != "" {
+				body = "small"
+			}
+			c.Data(http.StatusOK, c.Query("type"), []byte(body))
+		})
+		for contentType, expected := range tt.expected {
+			for _, query := range []string{"", "&small=1"} {
+				req, _ := http.NewRequestWithContext(context.Background(), "GET",
+					"/?type="+url.QueryEscape(contentType)+query, nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if query != "" {
+					expected = ""
+				}
+				assert.Equal(t, expected, w.Header().Get("Content-Encoding"), tt.name+" "+contentType+query)
+			}
+		}
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds record no statistics")
+	}
+	gin.SetMode(gin.TestMode)
+	before := ReadStats()
+
+	body := strings.Repeat("Gzip Test Response ", 100)
+	router := gin.New()
+	router.GET("/stats", StatsHandler())
+	router.GET("/office/stats", StatsHandler("192.0.2.0/24"))
+	router.Use(Gzip(DefaultCompression, WithStats(), WithDecompressFn(DefaultDecompressHandle)))
+	router.Any("/items/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, body)
+	})
+
+	for _, header := range []http.Header{
+		{"Accept-Encoding": {"gzip"}},
+		{"Accept-Encoding": {"gzip"}},
+		{},
+		{"Accept-Encoding": {"gzip"}, "Content-Encoding": {"gzip\x00"}},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/items/1", nil)
+		req.Header = header
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for _, tt := range []struct {
+		path       string
+		remoteAddr string
+		expected   int
+	}{
+		{"/stats", "127.0.0.1:1234", http.StatusNotFound},
+		{"/stats", "192.0.2.1:1234", http.StatusNotFound},
+		{"/office/stats", "127.0.0.1:1234", http.StatusNotFound},
+		{"/office/stats", "192.0.2.1:1234", http.StatusOK},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tt.expected, w.Code, "%s %s", tt.path, tt.remoteAddr)
+	}
+	assert.Panics(t, func() { StatsHandler("192.0.2.0/33") })
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/office/stats", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var after Stats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &after))
+
+	assert.Equal(t, before.Compressed+2, after.Compressed)
+	assert.Equal(t, before.Skipped[ReasonAcceptEncoding]+1, after.Skipped[ReasonAcceptEncoding])
+	assert.Equal(t, before.DecompressFailures+1, after.DecompressFailures)
+	route := after.Routes["/items/:id"]
+	assert.Equal(t, int64(2), route.Compressed)
+	assert.Equal(t, int64(2*len(body)), route.OriginalBytes)
+	assert.Greater(t, route.BytesSaved, int64(0))
+	assert.Greater(t, route.AverageRatio, 10.0)
+}
+
+func TestSizeObserver(t *testing.T) {
+	type observation struct {
+		route                string
+		original, compressed int
+	}
+	var observed []observation
+	body := strings.Repeat("Gzip Test Response ", 100)
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithSizeObserver(func(route string, original, compressed int) {
+		observed = append(observed, observation{route, original, compressed})
+	})))
+	router.GET("/items/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, body)
+	})
+
+	for _, acceptEncoding := range []string{"gzip", ""} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/items/1", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if acceptEncoding == "gzip" {
+			assert.Equal(t, []observation{{"/items/:id", len(body), w.Body.Len()}}, observed)
+		}
+	}
+	// uncompressed responses are not observed
+	assert.Len(t, observed, 1)
+}
+
+func TestLevelRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression, WithLevelRules(map[string]int{
+		"/api/*":          BestSpeed,
+		"/api/reports/*":  BestCompression,
+		"/users/:id":      HuffmanOnly,
+		"/api/reports/42": NoCompression,
+	}))
+	tests := []struct {
+		route         string
+		path          string
+		expectedLevel int
+	}{
+		{"/api/*path", "/api/books", BestSpeed},
+		{"/api/*path", "/api/reports/1", BestCompression},
+		{"/api/*path", "/api/reports/42", NoCompression},
+		{"/users/:id", "/users/7", HuffmanOnly},
+		{"/", "/", DefaultCompression},
+	}
+	for _, tt := range tests {
+		var level int
+		router := gin.New()
+		router.GET(tt.route, func(c *gin.Context) {
+			level = handler.pool(c).level
+		})
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", tt.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, tt.expectedLevel, level, tt.path)
+	}
+
+	_, err := New(DefaultCompression, WithLevelRules(map[string]int{"/api/*": 11}))
+	assert.ErrorContains(t, err, "invalid compression level 11")
+}
+
+func TestHandleSkipPathAllocations(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds compile out regular expressions")
+	}
+	if raceEnabled {
+		t.Skip("allocations are not counted reliably with -race")
+	}
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(gin.TestMode)
+
+	newRouter := func(middleware ...gin.HandlerFunc) *gin.Engine {
+		router := gin.New()
+		router.Use(middleware...)
+		router.Any("/*path", func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		return router
+	}
+	plain := newRouter()
+	router := newRouter(Gzip(DefaultCompression,
+		WithExcludedPaths([]string{"/api/"}),
+		WithExcludedPathsRegexs([]string{`^/reports/\d+$`}),
+	))
+
+	w := httptest.NewRecorder()
+	allocs := func(router *gin.Engine, req *http.Request) float64 {
+		return testing.AllocsPerRun(100, func() {
+			router.ServeHTTP(w, req)
+		})
+	}
+	for _, tt := range []struct {
+		method         string
+		path           string
+		acceptEncoding string
+	}{
+		{"GET", "/", ""},
+		{"GET", "/", "br, gzip;q=0"},
+		{"HEAD", "/", "gzip"},
+		{"GET", "/logo.png", "gzip"},
+		{"GET", "/api/books", "gzip"},
+		{"GET", "/reports/42", "gzip"},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), tt.method, tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		assert.Equal(t, allocs(plain, req), allocs(router, req), "%s %s %q", tt.method, tt.path, tt.acceptEncoding)
+	}
+
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		assert.True(t, DefaultExcludedContentTypes.Contains("Video/MP4; codecs=avc1"))
+		assert.False(t, DefaultExcludedContentTypes.Contains("Text/HTML"))
+	}))
+}
+
+// Run with -tags klauspost to compare against the klauspost/compress backend.
+func BenchmarkHandleGzip(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+
+	for _, size := range []int{1 << 10, 64 << 10, 1 << 20} {
+		var sb strings.Builder
+		for i := 0; sb.Len() < size; i++ {
+			fmt.Fprintf(&sb, `{"id":%d,"name":"item-%d","tags":["a","b"]}`+"\n", i, i*7)
+		}
+		body := sb.String()
+
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			router := gin.New()
+			router.Use(Gzip(DefaultCompression))
+			router.GET("/", func(c *gin.Context) {
+				c.String(http.StatusOK, body)
+			})
+
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				router.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func TestRequestSkipReason(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds compile out regular expressions")
+	}
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression,
+		WithExcludedPaths([]string{"/api/"}),
+		WithExcludedPathsRegexs([]string{`^/reports/\d+$`}),
+		WithExcludedPathsFunc(func(p string) bool {
+			ok, _ := path.Match("/assets/*/bundle", p)
+			return ok
+		}),
+		WithCustomShouldCompressFn(func(c *gin.Context) bool {
+			return c.GetHeader("X-No-Gzip") == ""
+		}),
+	)
+
+	tests := []struct {
+		method   string
+		path     string
+		header   http.Header
+		expected string
+	}{
+		{"GET", "/", http.Header{}, ReasonAcceptEncoding},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "Connection": {"Upgrade"}}, ReasonUpgrade},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "Connection": {"keep-alive, upgrade"}}, ReasonUpgrade},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "Connection": {"keep-alive", "UPGRADE"}}, ReasonUpgrade},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "Upgrade": {"websocket"}}, ReasonUpgrade},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "Connection": {"X-Upgraded-By"}}, ""},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "Accept": {"text/event-stream"}}, ReasonEventStream},
+		{"HEAD", "/", http.Header{"Accept-Encoding": {"gzip"}}, ReasonMethod},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=0-9"}}, ReasonRange},
+		{"GET", "/logo.png", http.Header{"Accept-Encoding": {"gzip"}}, ReasonExtension},
+		{"GET", "/api/books", http.Header{"Accept-Encoding": {"gzip"}}, ReasonPath},
+		{"GET", "/reports/42", http.Header{"Accept-Encoding": {"gzip"}}, ReasonPathRegex},
+		{"GET", "/assets/v2/bundle", http.Header{"Accept-Encoding": {"gzip"}}, ReasonPathMatcher},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}, "X-No-Gzip": {"1"}}, ReasonCustom},
+		// earlier stages win over later ones
+		{"HEAD", "/api/logo.png", http.Header{"Accept-Encoding": {"gzip"}}, ReasonMethod},
+		{"GET", "/", http.Header{"Accept-Encoding": {"gzip"}}, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), tt.method, tt.path, nil)
+		req.Header = tt.header
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+
+		assert.Equal(t, tt.expected, handler.requestSkipReason(c), "%s %s", tt.method, tt.path)
+	}
+}
+
+func TestRequestSkipReasonIncludedPaths(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds compile out regular expressions")
+	}
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression,
+		WithIncludedPaths([]string{"/api/"}),
+		WithIncludedPathsRegexs([]string{`^/reports/\d+$`}),
+		WithExcludedPaths([]string{"/api/internal/"}),
+	)
+	for path, expected := range map[string]string{
+		"/api/books":        "",
+		"/reports/42":       "",
+		"/":                 ReasonIncludedPath,
+		"/reports/all":      ReasonIncludedPath,
+		"/api/internal/dbg": ReasonPath,
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		assert.Equal(t, expected, handler.requestSkipReason(c), path)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	c.Request.Header.Set("Accept-Encoding", "gzip")
+	assert.Equal(t, "", newGzipHandler(DefaultCompression).requestSkipReason(c))
+}
+
+func TestNewInvalidPathRegexs(t *testing.T) {
+	assert.NotPanics(t, func() {
+		_, err := New(DefaultCompression, WithExcludedPathsRegexs([]string{"(unclosed"}))
+		assert.ErrorContains(t, err, "excluded path regex")
+		_, err = New(DefaultCompression, WithIncludedPathsRegexs([]string{`^/api/`, "[a-"}))
+		assert.ErrorContains(t, err, "included path regex")
+	})
+	assert.Panics(t, func() {
+		Gzip(DefaultCompression, WithExcludedPathsRegexs([]string{"(unclosed"}))
+	})
+}
+
+func TestRequestSkipReasonCaseInsensitive(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds compile out regular expressions")
+	}
+	gin.SetMode(gin.TestMode)
+
+	options := []Option{
+		WithAdditionalExcludedExtensions([]string{".PDF"}),
+		WithExcludedPaths([]string{"/Static/"}),
+		WithExcludedPathsRegexs([]string{`^/reports/\d+$`}),
+		WithIncludedPaths([]string{"/api/", "/static/", "/reports/", "/docs/"}),
+	}
+	sensitive := newGzipHandler(DefaultCompression, options...)
+	insensitive := newGzipHandler(DefaultCompression, append(options, WithCaseInsensitiveMatching())...)
+
+	for path, expected := range map[string][2]string{
+		"/api/IMAGE.PNG":  {"", ReasonExtension},
+		"/docs/guide.pdf": {"", ReasonExtension},
+		"/STATIC/app.js":  {ReasonIncludedPath, ReasonPath},
+		"/static/app.js":  {"", ReasonPath},
+		"/REPORTS/42":     {ReasonIncludedPath, ReasonPathRegex},
+		"/API/books":      {ReasonIncludedPath, ""},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		assert.Equal(t, expected[0], sensitive.requestSkipReason(c), path)
+		assert.Equal(t, expected[1], insensitive.requestSkipReason(c), path)
+	}
+}
+
+func TestRequestSkipReasonQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression, WithExcludedQueryParams(map[string][]string{
+		"download": {"1", "true"},
+		"raw":      nil,
+	}))
+	for target, expected := range map[string]string{
+		"/report?download=1":           ReasonQuery,
+		"/report?page=2&download=true": ReasonQuery,
+		"/report?download=0":           "",
+		"/report?raw":                  ReasonQuery,
+		"/report?raw=anything":         ReasonQuery,
+		"/report?downloads=1":          "",
+		"/report":                      "",
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		assert.Equal(t, expected, handler.requestSkipReason(c), target)
+	}
+}
+
+func TestRequestSkipReasonInternal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression,
+		WithSkipInternal([]string{"10.0.0.0/8"}),
+		WithSkipInternal([]string{"::1/128"}),
+	)
+	for remoteAddr, expected := range map[string]string{
+		"10.1.2.3:4567":          ReasonInternal,
+		"[::ffff:10.1.2.3]:4567": ReasonInternal,
+		"[::1]:4567":             ReasonInternal,
+		"192.0.2.1:4567":         "",
+		"127.0.0.1:4567":         "",
+		"garbage":                "",
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("X-Forwarded-For", "10.1.2.3")
+		req.RemoteAddr = remoteAddr
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		assert.Equal(t, expected, handler.requestSkipReason(c), remoteAddr)
+	}
+
+	_, err := New(DefaultCompression, WithSkipInternal([]string{"10.0.0.0/33"}))
+	assert.Error(t, err)
+}
+
+func TestRequestSkipReasonAcceptEncoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		acceptEncoding string
+		wildcard       bool
+		expected       string
+	}{
+		{"gzip", true, ""},
+		{"GZIP", true, ""},
+		{"x-gzip", true, ""},
+		{"br, gzip;q=0.5", true, ""},
+		{"gzip ; q=1.0", true, ""},
+		{"gzip;q=0", true, ReasonAcceptEncoding},
+		{"gzip;q=0.000", true, ReasonAcceptEncoding},
+		{"gzip;q=oops", true, ReasonAcceptEncoding},
+		{"gzipper", true, ReasonAcceptEncoding},
+		{"br", true, ReasonAcceptEncoding},
+		{"*", true, ""},
+		{"br, *;q=0.1", true, ""},
+		{"*;q=0", true, ReasonAcceptEncoding},
+		{"gzip;q=0, *", true, ReasonAcceptEncoding},
+		{"*", false, ReasonAcceptEncoding},
+		{"gzip, *", false, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+
+		handler := newGzipHandler(DefaultCompression, WithAllowWildcardAccept(tt.wildcard))
+		assert.Equal(t, tt.expected, handler.requestSkipReason(c), "%q wildcard=%v", tt.acceptEncoding, tt.wildcard)
+	}
+}
+
+func TestCachedAcceptEncoding(t *testing.T) {
+	// more values than slots, so some evict others
+	values := []string{"gzip", "br", "gzip;q=0", "*;q=0", "identity;q=0"}
+	for i := 0; i < 2*acceptCacheSize; i++ {
+		values = append(values, "br, gzip;q=0."+strconv.Itoa(i))
+	}
+	values = append(values, "gzip, "+strings.Repeat("x", maxCachedAcceptEncoding))
+
+	for round := 0; round < 2; round++ {
+		for _, v := range values {
+			assert.Equal(t, parseAcceptEncoding([]string{v}), cachedAcceptEncoding([]string{v}), v)
+		}
+	}
+	assert.Equal(t, parseAcceptEncoding([]string{"br", "gzip"}), cachedAcceptEncoding([]string{"br", "gzip"}))
+	assert.Equal(t, parseAcceptEncoding(nil), cachedAcceptEncoding(nil))
+}
+
+func TestStrictNegotiation(t *testing.T) {
+	tests := []struct {
+		acceptEncoding          string
+		strict                  bool
+		expectedStatus          int
+		expectedContentEncoding string
+	}{
+		{"gzip, identity;q=0", true, http.StatusOK, "gzip"},
+		{"br", true, http.StatusOK, ""},
+		{"br, identity;q=0", true, http.StatusNotAcceptable, ""},
+		{"br, *;q=0", true, http.StatusNotAcceptable, ""},
+		{"br, identity, *;q=0", true, http.StatusOK, ""},
+		{"gzip;q=0, identity;q=0", true, http.StatusNotAcceptable, ""},
+		{"br, identity;q=0", false, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		var options []Option
+		if tt.strict {
+			options = append(options, WithStrictNegotiation())
+		}
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, options...))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, testResponse)
+		})
+
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.acceptEncoding)
+	}
+}
+
+func TestRequestSkipReasonHeaderRules(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds compile out regular expressions")
+	}
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression,
+		WithRequestHeaderRules(RequestHeaderRule{Name: "user-agent", Value: `MSIE [1-6]\.`}),
+		WithRequestHeaderRules(RequestHeaderRule{Name: "X-Internal-Caller"}),
+	)
+	for _, tt := range []struct {
+		header   http.Header
+		expected string
+	}{
+		{http.Header{"User-Agent": {"Mozilla/4.0 (compatible; MSIE 6.0; Windows NT 5.1)"}}, ReasonRequestHeader},
+		{http.Header{"User-Agent": {"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1)"}}, ""},
+		{http.Header{"X-Internal-Caller": {"billing"}}, ReasonRequestHeader},
+		{http.Header{"X-Internal-Caller": {""}}, ReasonRequestHeader},
+		{http.Header{}, ""},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header = tt.header
+		req.Header.Set("Accept-Encoding", "gzip")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		assert.Equal(t, tt.expected, handler.requestSkipReason(c), "%v", tt.header)
+	}
+
+	_, err := New(DefaultCompression, WithRequestHeaderRules(RequestHeaderRule{Name: "User-Agent", Value: "("}))
+	assert.ErrorContains(t, err, "request header rule for User-Agent")
+}
+
+func TestRequestSkipReasonBrokenProxies(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds compile out regular expressions")
+	}
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression,
+		WithNoCompressionHeader("X-No-Compression"),
+		WithBrokenProxies("squid/2.6", "old-cache"),
+	)
+	for _, tt := range []struct {
+		header   http.Header
+		expected string
+	}{
+		{http.Header{"X-No-Compression": {"1"}}, ReasonRequestHeader},
+		{http.Header{"Via": {"1.0 fred, 1.1 Squid/2.6.STABLE21"}}, ReasonRequestHeader},
+		{http.Header{"Via": {"1.1 edge", "1.1 OLD-CACHE (v1)"}}, ReasonRequestHeader},
+		{http.Header{"Via": {"1.1 squid/3.5"}}, ""},
+		{http.Header{}, ""},
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header = tt.header
+		req.Header.Set("Accept-Encoding", "gzip")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		assert.Equal(t, tt.expected, handler.requestSkipReason(c), "%v", tt.header)
+	}
+}
+
+func TestRequestSkipReasonUpgradeBypass(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "h2c")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+
+	assert.Equal(t, ReasonUpgrade, newGzipHandler(DefaultCompression, WithUpgradeBypass(true)).requestSkipReason(c))
+	assert.Equal(t, "", newGzipHandler(DefaultCompression, WithUpgradeBypass(false)).requestSkipReason(c))
+}
+
+func TestHandleDecompressShouldFn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	buf := &bytes.Buffer{}
+	gz, _ := gzip.NewWriterLevel(buf, gzip.DefaultCompression)
+	_, _ = gz.Write([]byte("Gzip Test Response"))
+	gz.Close()
+
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression,
+		WithDecompressFn(DefaultDecompressHandle),
+		WithDecompressShouldFn(func(c *gin.Context) bool {
+			return c.Request.URL.Path == "/upload"
+		}),
+	))
+	handler := func(c *gin.Context) {
+		data, err := c.GetRawData()
+		assert.NoError(t, err)
+		c.Data(http.StatusOK, "application/octet-stream", data)
+	}
+	router.POST("/upload", handler)
+	router.POST("/raw", handler)
+
+	for _, path := range []string{"/upload", "/raw"} {
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", path, bytes.NewReader(buf.Bytes()))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		if path == "/upload" {
+			assert.Equal(t, "Gzip Test Response", w.Body.String())
+		} else {
+			assert.Equal(t, buf.Bytes(), w.Body.Bytes())
+		}
+	}
+}
+
+func TestHandleGzipFaultInjection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	writeErr := errors.New("disk full")
+	closeErr := errors.New("broken pipe")
+
+	var errs []error
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		for _, err := range c.Errors {
+			errs = append(errs, err.Err)
+		}
+	})
+	router.Use(Gzip(DefaultCompression, WithFaultInjection(FaultInjection{
+		WriteErr:      writeErr,
+		WriteErrAfter: 10,
+		CloseErr:      closeErr,
+		WriteDelay:    time.Millisecond,
+	})))
+	router.GET("/", func(c *gin.Context) {
+		start := time.Now()
+		n, err := c.Writer.Write([]byte("Gzip Test Response"))
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond)
+		assert.Equal(t, 10, n)
+		assert.ErrorIs(t, err, writeErr)
+	})
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, []error{closeErr}, errs)
+}
+
+func TestHandleGzipMinimalMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newGzipHandler(DefaultCompression,
+		WithStatsTrailers(),
+		MinimalMode(),
+		WithExcludedPaths([]string{"/api/"}),
+	)
+	assert.False(t, handler.StatsTrailers)
+	assert.Nil(t, handler.DecompressFn)
+	assert.Equal(t, 1, cap(handler.gzPool.idle))
+	assert.Equal(t, ExcludedPaths{"/api/"}, handler.ExcludedPaths)
+	assert.True(t, handler.AllowWildcardAccept)
+
+	router := gin.New()
+	router.Use(handler.Handle)
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "Gzip Test Response")
+	})
+
+	for _, acceptEncoding := range []string{"gzip", "*"} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), acceptEncoding)
+		gr, err := gzip.NewReader(w.Body)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(gr)
+		assert.Equal(t, "Gzip Test Response", string(body))
+	}
+}
+
+func TestMinimalBuild(t *testing.T) {
+	if !minimalBuild {
+		t.Skip("build with -tags gzipminimal")
+	}
+	_, err := New(DefaultCompression, WithExcludedPathsRegexs([]string{`^/reports/`}))
+	assert.ErrorContains(t, err, "gzipminimal")
+	_, err = New(DefaultCompression, WithBrokenProxies("squid/2.6"))
+	assert.ErrorContains(t, err, "gzipminimal")
+	_, err = New(DefaultCompression, WithPrecompressedFS(fstest.MapFS{}))
+	assert.ErrorContains(t, err, "gzipminimal")
+	assert.Panics(t, func() { StaticFS(fstest.MapFS{}) })
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "gzip.yaml")
+	assert.NoError(t, os.WriteFile(name, []byte("level: 1\n"), 0o600))
+	_, err = NewFromConfigFile(name)
+	assert.ErrorContains(t, err, "gzipminimal")
+	name = filepath.Join(dir, "gzip.json")
+	assert.NoError(t, os.WriteFile(name, []byte(`{"level": 1}`), 0o600))
+	_, err = NewFromConfigFile(name)
+	assert.NoError(t, err)
+
+	before := ReadStats()
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, MinimalMode(), WithStats()))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, before.Compressed, ReadStats().Compressed)
+}
+
+func TestHandler(t *testing.T) {
+	h, err := NewHandler(DefaultCompression, WithDecompressFn(DefaultDecompressHandle),
+		WithExcludedPaths([]string{"/plain"}), WithStatsTrailers(), WithTrustedNetworks([]string{"0.0.0.0/0"}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if err := h.Decompress(c); err != nil {
+			return
+		}
+		if !h.ShouldCompress(c.Request) {
+			c.Next()
+			return
+		}
+		w := h.WrapWriter(c.Writer)
+		c.Writer = w
+		defer func() {
+			_ = w.(io.Closer).Close()
+		}()
+		c.Next()
+	})
+	router.POST("/*path", func(c *gin.Context) {
+		data, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(data))
+	})
+
+	tests := []struct {
+		name                    string
+		path                    string
+		body                    []byte
+		expectedStatus          int
+		expectedContentEncoding string
+		expectedBody            string
+	}{
+		{"compresses", "/", gzipBytes(testResponse), http.StatusOK, "gzip", testResponse},
+		{"skips", "/plain", gzipBytes(testResponse), http.StatusOK, "", testResponse},
+		{"rejects invalid bodies", "/", []byte("not gzip"), http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", tt.path, bytes.NewReader(tt.body))
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.name)
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.name)
+		body := w.Body.String()
+		if tt.expectedContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(w.Body)
+			if !assert.NoError(t, err, tt.name) {
+				continue
+			}
+			data, _ := io.ReadAll(gr)
+			body = string(data)
+		}
+		assert.Equal(t, tt.expectedBody, body, tt.name)
+	}
+
+	_, err = NewHandler(42)
+	assert.Error(t, err)
+}
+
+func TestMultipartDecompression(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("name", "report")
+	part, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="file"; filename="report.txt"`},
+		"Content-Encoding":    {"gzip"},
+	})
+	_, _ = part.Write(gzipBytes(testResponse))
+	part, _ = mw.CreateFormFile("plain", "plain.txt")
+	_, _ = part.Write([]byte("plain"))
+	_ = mw.Close()
+
+	tests := []struct {
+		name           string
+		options        []Option
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"decodes gzip parts", nil, http.StatusOK, "report " + testResponse + " plain"},
+		{"limits part size", []Option{WithMaxDecompressedSize(8)}, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.Use(Gzip(DefaultCompression, append(tt.options, WithMultipartDecompression())...))
+		router.POST("/", func(c *gin.Context) {
+			form, err := c.MultipartForm()
+			if err != nil {
+				c.Status(http.StatusBadRequest)
+				return
+			}
+			var files []string
+			for _, field := range []string{"file", "plain"} {
+				f, _ := form.File[field][0].Open()
+				data, _ := io.ReadAll(f)
+				f.Close()
+				files = append(files, string(data))
+			}
+			c.String(http.StatusOK, "%s %s", form.Value["name"][0], strings.Join(files, " "))
+		})
+
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", bytes.NewReader(buf.Bytes()))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.name)
+		assert.Equal(t, tt.expectedBody, w.Body.String(), tt.name)
+	}
+}
+
+func TestMaxDecompressionRatio(t *testing.T) {
+	var events []Event
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithDecompressFn(DefaultDecompressHandle), WithMaxDecompressionRatio(100),
+		WithEventHook(func(_ *gin.Context, e Event) {
+			if e.Kind == EventDecompressFailed {
+				events = append(events, e)
+			}
+		})))
+	router.POST("/", func(c *gin.Context) {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return
+		}
+		c.String(http.StatusOK, "%d", len(data))
+	})
+
+	random := make([]byte, 256<<10)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedEvents int
+	}{
+		{"small repetitive", strings.Repeat("a", 32<<10), http.StatusOK, 0},
+		{"large incompressible", hex.EncodeToString(random), http.StatusOK, 0},
+		{"bomb", strings.Repeat("a", 8<<20), http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		events = nil
+		req, _ := http.NewRequestWithContext(context.Background(), "POST", "/", bytes.NewReader(gzipBytes(tt.body)))
+		req.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.name)
+		if tt.expectedStatus == http.StatusOK {
+			assert.Equal(t, strconv.Itoa(len(tt.body)), w.Body.String(), tt.name)
+		}
+		if assert.Len(t, events, tt.expectedEvents, tt.name) && tt.expectedEvents > 0 {
+			assert.ErrorIs(t, events[0].Err, ErrDecompressionRatio, tt.name)
+		}
+	}
+}
+
+func TestHandlerReconfigure(t *testing.T) {
+	h, err := NewHandler(DefaultCompression)
+	if !assert.NoError(t, err) {
+		return
+	}
+	router := gin.New()
+	router.Use(h.Handle)
+	router.GET("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+	get := func(path string) string {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Get("Content-Encoding")
+	}
+
+	assert.Equal(t, "gzip", get("/a"))
+	assert.NoError(t, h.Reconfigure(WithExcludedPaths([]string{"/a"})))
+	assert.Equal(t, "", get("/a"))
+	assert.NoError(t, h.Reconfigure(WithMinLength(1024)))
+	assert.Equal(t, "", get("/a"))
+	assert.Equal(t, "", get("/b"))
+	assert.Error(t, h.Reconfigure(WithLevel(42)))
+	assert.Equal(t, "", get("/b"))
+	assert.NoError(t, h.Reconfigure(WithMinLength(0), WithLevel(BestSpeed)))
+	assert.Equal(t, "gzip", get("/b"))
+	assert.NotPanics(t, func() {
+		assert.Error(t, h.Reconfigure(WithExcludedPathsRegexs([]string{"/b("})))
+	})
+	assert.Equal(t, "gzip", get("/b"))
+	assert.Equal(t, "", get("/a"))
+
+	// requests race with reconfiguration
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			_ = h.Reconfigure(WithMinLength(n))
+		}(i)
+		go func() {
+			defer wg.Done()
+			get("/b")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewFromConfigFile(t *testing.T) {
+	if minimalBuild {
+		t.Skip("gzipminimal builds only read JSON config files")
+	}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "gzip.yaml")
+	assert.NoError(t, os.WriteFile(name, []byte("level: 1\nexcluded_paths: [/metrics]\n"), 0o600))
+
+	h, err := NewFromConfigFile(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	router := gin.New()
+	router.Use(h.Handle)
+	router.GET("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, testResponse)
+	})
+	get := func(path string) string {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Get("Content-Encoding")
+	}
+	assert.Equal(t, "", get("/metrics"))
+	assert.Equal(t, "gzip", get("/api"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	h.WatchConfigFile(ctx, name, 10*time.Millisecond, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+
+	assert.NoError(t, os.WriteFile(name, []byte("excluded_paths: [/api, /health]\n"), 0o600))
+	assert.Eventually(t, func() bool { return get("/api") == "" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "gzip", get("/metrics"))
+
+	assert.NoError(t, os.WriteFile(name, []byte("excluded_pathz: [/metrics]\n"), 0o600))
+	select {
+	case err := <-errs:
+		assert.ErrorContains(t, err, "excluded_pathz")
+	case <-time.After(time.Second):
+		t.Error("invalid config file was not reported")
+	}
+	assert.Equal(t, "", get("/api"))
+
+	for file, content := range map[string]string{
+		"bad.json":   `{"level": 12}`,
+		"typo.json":  `{"min_lenght": 10}`,
+		"broken.yml": "level: [",
+	} {
+		name := filepath.Join(dir, file)
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o600))
+		_, err := NewFromConfigFile(name)
+		assert.Error(t, err, file)
+	}
+	_, err = NewFromConfigFile(filepath.Join(dir, "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// panickingWriter panics on the first write reaching it, in the middle of the
+// compressor's own write.
+type panickingWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *panickingWriter) Write([]byte) (int, error) {
+	panic("connection gone")
+}
+
+func TestHandlePanicDiscardsWriter(t *testing.T) {
+	handler := newGzipHandler(DefaultCompression, WithPoolConfig(1, 1, 0))
+	assert.Len(t, handler.gzPool.idle, 1)
+
+	router := gin.New()
+	router.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, _ interface{}) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	router.Use(func(c *gin.Context) {
+		if c.Query("fail") == "writer" {
+			c.Writer = &panickingWriter{c.Writer}
+		}
+		c.Next()
+	})
+	router.Use(handler.Handle)
+	router.GET("/", func(c *gin.Context) {
+		_, _ = c.Writer.WriteString(strings.Repeat(testResponse, 1000))
+		c.Writer.Flush()
+		if c.Query("fail") == "handler" {
+			panic("boom")
+		}
+	})
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/?"+query, nil)
+		req.Header.Add("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, query := range []string{"fail=handler", "fail=writer"} {
+		get(query)
+		assert.Empty(t, handler.gzPool.idle, query)
+
+		w := get("")
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), query)
+		gr, err := gzip.NewReader(w.Body)
+		if assert.NoError(t, err, query) {
+			data, err := io.ReadAll(gr)
+			assert.NoError(t, err, query)
+			assert.Equal(t, strings.Repeat(testResponse, 1000), string(data), query)
+		}
+		assert.Len(t, handler.gzPool.idle, 1, query)
+	}
+	assert.Zero(t, outstandingWriters.Load())
+}
+
+func TestDictionaries(t *testing.T) {
+	// random identifiers do not compress well on their own
+	rnd := rand.New(rand.NewSource(1))
+	names := make([]byte, 4<<10)
+	for i := range names {
+		names[i] = byte('a' + rnd.Intn(26))
+	}
+	script := func(version string) string {
+		return "export const names = '" + string(names) + "'\nexport const version = '" + version + "'\n"
+	}
+	dict := []byte(script("1.0.0"))
+	if !dictionaryCompression {
+		_, err := New(DefaultCompression, WithDictionaries(dict))
+		assert.ErrorContains(t, err, "klauspost")
+		return
+	}
+	gin.SetMode(gin.TestMode)
+	body := script("1.0.1")
+	router := gin.New()
+	router.Use(Gzip(DefaultCompression, WithDictionaries(dict), WithETagVariant()))
+	router.GET("/app.js", func(c *gin.Context) {
+		c.Header("ETag", `"v2"`)
+		c.String(http.StatusOK, body)
+	})
+
+	sum := sha256.Sum256(dict)
+	available := ":" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	other := sha256.Sum256([]byte("other"))
+	tests := []struct {
+		name                    string
+		acceptEncoding          string
+		availableDictionary     string
+		expectedContentEncoding string
+	}{
+		{"dictionary", "gzip, br, zstd, dcb, dcz", available, "dcz"},
+		{"unknown dictionary", "gzip, dcz", ":" + base64.StdEncoding.EncodeToString(other[:]) + ":", "gzip"},
+		{"malformed dictionary", "gzip, dcz", available[1:], "gzip"},
+		{"dcz not accepted", "gzip", available, "gzip"},
+		{"dcz refused", "gzip, dcz;q=0", available, "gzip"},
+		{"no dictionary", "gzip, dcz", "", "gzip"},
+	}
+	sizes := map[string]int{}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		req.Header.Set("Available-Dictionary", tt.availableDictionary)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedContentEncoding, w.Header().Get("Content-Encoding"), tt.name)
+		assert.Equal(t, []string{"Accept-Encoding", "Available-Dictionary"}, w.Header().Values("Vary"), tt.name)
+		assert.Equal(t, `"v2-`+tt.expectedContentEncoding+`"`, w.Header().Get("ETag"), tt.name)
+		sizes[tt.expectedContentEncoding] = w.Body.Len()
+		if tt.expectedContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(w.Body)
+			if assert.NoError(t, err) {
+				data, _ := io.ReadAll(gr)
+				assert.Equal(t, body, string(data), tt.name)
+			}
+			continue
+		}
+		header := append([]byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}, sum[:]...)
+		if !assert.True(t, bytes.HasPrefix(w.Body.Bytes(), header), tt.name) {
+			continue
+		}
+		zr, err := zstd.NewReader(bytes.NewReader(w.Body.Bytes()[len(header):]), zstd.WithDecoderDictRaw(0, dict))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		data, err := io.ReadAll(zr)
+		zr.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(data), tt.name)
+	}
+	assert.Less(t, sizes["dcz"], sizes["gzip"]/10)
+}
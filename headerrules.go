@@ -0,0 +1,55 @@
+package gzip
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// RequestHeaderRule matches requests by a header, see WithRequestHeaderRules.
+type RequestHeaderRule struct {
+	// Name is the header name, e.g. "User-Agent".
+	Name string `json:"name" yaml:"name"`
+	// Value is a regular expression one of the header's values must match.
+	// An empty Value matches any request carrying the header.
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// headerRule is a RequestHeaderRule with its expression compiled.
+type headerRule struct {
+	name  string
+	value *regexp.Regexp
+}
+
+// newHeaderRules compiles rules, reporting the first invalid expression.
+func newHeaderRules(rules []RequestHeaderRule) ([]headerRule, error) {
+	res := make([]headerRule, 0, len(rules))
+	for _, rule := range rules {
+		r := headerRule{name: http.CanonicalHeaderKey(rule.Name)}
+		if rule.Value != "" {
+			re, err := compileHeaderValue(rule.Value)
+			if err != nil {
+				return nil, fmt.Errorf("gzip: request header rule for %s: %w", rule.Name, err)
+			}
+			r.value = re
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// matchHeaderRules reports whether one of rules matches header.
+func matchHeaderRules(rules []headerRule, header http.Header) bool {
+	for _, rule := range rules {
+		values := header[rule.name]
+		if len(values) > 0 && rule.value == nil {
+			return true
+		}
+		for _, v := range values {
+			if matchHeaderValue(rule.value, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build !gzipminimal
+
+package gzip
+
+import "regexp"
+
+// compileHeaderValue compiles the Value of a RequestHeaderRule.
+func compileHeaderValue(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(expr)
+}
+
+func matchHeaderValue(re *regexp.Regexp, v string) bool {
+	return re.MatchString(v)
+}
@@ -0,0 +1,18 @@
+//go:build gzipminimal
+
+package gzip
+
+import (
+	"errors"
+	"regexp"
+)
+
+// compileHeaderValue reports any RequestHeaderRule Value: gzipminimal builds
+// only match rules by the presence of their header.
+func compileHeaderValue(string) (*regexp.Regexp, error) {
+	return nil, errors.New("values are not available in gzipminimal builds")
+}
+
+func matchHeaderValue(*regexp.Regexp, string) bool {
+	return false
+}
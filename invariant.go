@@ -0,0 +1,19 @@
+package gzip
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// outstandingWriters counts the writers taken from any pool and not returned
+// yet. It drops back to zero once every response has finished.
+var outstandingWriters atomic.Int64
+
+// violation reports a broken internal invariant: it panics in gzipdebug
+// builds and prints a debug warning otherwise.
+func violation(format string, values ...interface{}) {
+	if invariantPanics {
+		panic(fmt.Sprintf("gzip: invariant violated: "+format, values...))
+	}
+	debugPrintWARNING("invariant violated: "+format, values...)
+}
@@ -0,0 +1,7 @@
+//go:build gzipdebug
+
+package gzip
+
+// invariantPanics makes broken internal invariants panic; it is set when
+// building with -tags gzipdebug.
+const invariantPanics = true
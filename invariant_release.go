@@ -0,0 +1,7 @@
+//go:build !gzipdebug
+
+package gzip
+
+// invariantPanics makes broken internal invariants panic; build with
+// -tags gzipdebug to set it.
+const invariantPanics = false
@@ -0,0 +1,60 @@
+package gzip
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// levelRule compresses the requests matching pattern with the writers of pool.
+type levelRule struct {
+	pattern string
+	prefix  bool
+	pool    *writerPool
+}
+
+// newLevelRules validates rules and returns them longest pattern first, with
+// one writer pool per distinct level.
+func newLevelRules(rules map[string]int, newPool func(level int) (*writerPool, error)) ([]levelRule, error) {
+	pools := make(map[int]*writerPool)
+	res := make([]levelRule, 0, len(rules))
+	for pattern, level := range rules {
+		if err := validateLevel(level); err != nil {
+			return nil, err
+		}
+		if pools[level] == nil {
+			pool, err := newPool(level)
+			if err != nil {
+				return nil, err
+			}
+			pools[level] = pool
+		}
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		res = append(res, levelRule{pattern: prefix, prefix: isPrefix, pool: pools[level]})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i].pattern) != len(res[j].pattern) {
+			return len(res[i].pattern) > len(res[j].pattern)
+		}
+		// an exact rule wins over a prefix rule of the same length
+		return !res[i].prefix && res[j].prefix
+	})
+	return res, nil
+}
+
+// levelRulePool returns the pool of the first rule matching the route or the
+// path of the request, or nil.
+func (g *gzipHandler) levelRulePool(c *gin.Context) *writerPool {
+	route, path := c.FullPath(), c.Request.URL.Path
+	for _, rule := range g.levelRules {
+		if rule.prefix {
+			if strings.HasPrefix(route, rule.pattern) || strings.HasPrefix(path, rule.pattern) {
+				return rule.pool
+			}
+		} else if route == rule.pattern || path == rule.pattern {
+			return rule.pool
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package gzip
+
+import (
+	"io/fs"
+	"os"
+)
+
+// MinimalMode strips the configuration down to content negotiation and
+// compression with the default exclusions, dropping path exclusions, hooks,
+// trailers and request decompression, and keeps at most one idle gzip writer
+// between requests since every writer holds several hundred kilobytes of
+// compressor state. It resets any option given before it, so pass it first.
+// The optional subsystems stay in the binary; for memory-constrained
+// deployments such as IoT gateways, also build with -tags gzipminimal, which
+// compiles out the Accept-Encoding cache, the WithStats counters, regular
+// expression matching, YAML config files and static file serving, about
+// 90 KiB, making path regexes, request header rule values and precompressed
+// files fail New.
+func MinimalMode() Option {
+	return func(o *Options) {
+		*o = *DefaultOptions
+		o.PoolConfig = PoolConfig{MaxWriters: 1}
+	}
+}
+
+// GatewayMode configures the middleware in front of reverse proxies. Request
+// bodies are decompressed for upstreams that cannot read gzip, tolerating
+// clients that mislabel plain bodies, and response bodies are sniffed so
+// already compressed payloads an upstream did not label are passed through.
+// Bodies an upstream encoded itself are passed through, along with their
+// Content-Encoding, except gzip bodies sent to clients that do not accept
+// gzip, which are decoded as WithTranscode does. Pair it with WrapDirector on
+// the proxy so the upstream is asked for identity whenever the middleware
+// compresses, or with ProxyDirector to always ask for identity.
+func GatewayMode() Option {
+	return func(o *Options) {
+		o.DecompressFn = DefaultDecompressHandle
+		o.LenientDecompression = true
+		o.ContentSniffing = true
+		o.CompressUpgradeRequests = false
+		o.CompressRangeRequests = false
+		o.Transcode = true
+	}
+}
+
+// StaticSiteMode configures the middleware in front of single-page apps and
+// asset servers. Files with a precompressed root/<path>.gz sibling are served
+// from it, for requests no route matches or under WithPrecompressedPrefix,
+// other assets are compressed deterministically so every instance produces
+// the same bytes, already compressed formats and bodies under 1 KiB are left
+// alone, and the ETag of compressed responses names the coding.
+func StaticSiteMode(root string) Option {
+	return func(o *Options) {
+		StaticSiteModeFS(os.DirFS(root))(o)
+		o.PrecompressedRoot = root
+	}
+}
+
+// StaticSiteModeFS is StaticSiteMode looking up the <path>.gz siblings in
+// fsys, e.g. an embed.FS.
+func StaticSiteModeFS(fsys fs.FS) Option {
+	return func(o *Options) {
+		WithPrecompressedFS(fsys)(o)
+		o.Deterministic = true
+		o.ExcludedExtensions = DefaultExcludedExtentions
+		o.MinLength = 1024
+		o.ETagVariant = true
+	}
+}
+
+// apiContentTypes are the response types APIMode compresses.
+var apiContentTypes = []string{
+	"application/json", "application/problem+json", "application/vnd.api+json",
+	"application/x-ndjson", "application/protobuf", "application/x-protobuf",
+	"text/*",
+}
+
+// APIMode configures the middleware for REST and RPC services: JSON, protobuf
+// and text responses of at least 1 KiB are compressed at BestSpeed, gzip
+// request bodies are decompressed up to 10 MiB and stats are recorded for
+// StatsHandler. Add WithEventHook to feed other metrics systems.
+func APIMode() Option {
+	return func(o *Options) {
+		PresetAPI()(o)
+		o.DecompressFn = DefaultDecompressHandle
+		o.MaxDecompressedSize = 10 << 20
+		o.Stats = true
+	}
+}
@@ -0,0 +1,130 @@
+package gzip
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// partChunkSize bounds the bytes of a part re-encoded per read.
+const partChunkSize = 32 << 10
+
+// decompressParts makes the body of a multipart request decode its gzip
+// encoded parts as it is read, see Options.MultipartDecompression. The parts
+// keep their boundary, so c.MultipartForm and c.FormFile work unchanged.
+func (o *Options) decompressParts(c *gin.Context) {
+	mediaType, params, err := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" ||
+		c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return
+	}
+	r := &partsReader{
+		mr:      multipart.NewReader(c.Request.Body, params["boundary"]),
+		closer:  c.Request.Body,
+		maxSize: o.MaxDecompressedSize,
+		writer:  c.Writer,
+	}
+	r.mw = multipart.NewWriter(&r.buf)
+	if err := r.mw.SetBoundary(params["boundary"]); err != nil {
+		return
+	}
+	var body io.ReadCloser = r
+	if o.EventHook != nil {
+		body = &failureReporter{ReadCloser: body, report: func(err error) {
+			o.emit(c, Event{Kind: EventDecompressFailed, Err: err})
+		}}
+	}
+	req := c.Request.Clone(c.Request.Context())
+	req.Body = body
+	req.GetBody = nil
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	c.Request = req
+}
+
+// partsReader re-encodes a multipart body part by part, decoding the parts
+// whose Content-Encoding is gzip.
+type partsReader struct {
+	mr      *multipart.Reader
+	mw      *multipart.Writer
+	buf     bytes.Buffer
+	closer  io.Closer
+	maxSize int64
+	writer  http.ResponseWriter
+
+	// part is the body of the part being copied to dst, nil between parts.
+	part    io.Reader
+	decoder *decompressor
+	dst     io.Writer
+	err     error
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 && r.err == nil {
+		r.err = r.fill()
+	}
+	if r.buf.Len() > 0 {
+		return r.buf.Read(p)
+	}
+	return 0, r.err
+}
+
+// fill re-encodes the next part header or chunk of part body into buf.
+func (r *partsReader) fill() error {
+	if r.part == nil {
+		return r.nextPart()
+	}
+	_, err := io.CopyN(r.dst, r.part, partChunkSize)
+	if err == io.EOF {
+		r.part = nil
+		if r.decoder != nil {
+			err = r.decoder.Close()
+			r.decoder = nil
+		} else {
+			err = nil
+		}
+	}
+	return err
+}
+
+func (r *partsReader) nextPart() error {
+	part, err := r.mr.NextRawPart()
+	if err == io.EOF {
+		if err := r.mw.Close(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	header := textproto.MIMEHeader(http.Header(part.Header).Clone())
+	r.part = part
+	codings, err := parseCodings(header.Values("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	if len(codings) == 1 && (codings[0] == "gzip" || codings[0] == "x-gzip") {
+		if r.decoder, err = newDecompressor(part); err != nil {
+			return err
+		}
+		r.part = r.decoder
+		if r.maxSize > 0 {
+			r.part = http.MaxBytesReader(r.writer, r.decoder, r.maxSize)
+		}
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+	}
+	r.dst, err = r.mw.CreatePart(header)
+	return err
+}
+
+func (r *partsReader) Close() error {
+	return r.closer.Close()
+}
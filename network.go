@@ -0,0 +1,55 @@
+package gzip
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newNetworks parses the cidrs of the networks of kind, reporting the first
+// invalid one.
+func newNetworks(kind string, cidrs []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %s network: %w", kind, err)
+		}
+		res = append(res, prefix.Masked())
+	}
+	return res, nil
+}
+
+// fromNetworks reports whether the peer of c is in one of networks. The peer
+// is the address the connection comes from, not a client named in
+// X-Forwarded-For, which anyone can set.
+func fromNetworks(networks []netip.Prefix, c *gin.Context) bool {
+	return len(networks) > 0 && inNetworks(networks, c.RemoteIP())
+}
+
+// clientFromNetworks reports whether the client of c is in one of networks.
+// The client is resolved by c.ClientIP, so X-Forwarded-For and the like are
+// only believed when they come from the proxies the engine trusts, see
+// gin.Engine.SetTrustedProxies. The request of the context WrapWriter makes
+// has no peer, and no engine to resolve it, so it is in none.
+func clientFromNetworks(networks []netip.Prefix, c *gin.Context) bool {
+	if len(networks) == 0 || c.Request.RemoteAddr == "" {
+		return false
+	}
+	return inNetworks(networks, c.ClientIP())
+}
+
+func inNetworks(networks []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range networks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+//go:build !race
+
+package gzip
+
+// raceEnabled skips allocation counts, which the race detector and its
+// randomly emptied sync.Pools make unreliable.
+const raceEnabled = false
@@ -1,47 +1,368 @@
 package gzip
 
 import (
-	"compress/gzip"
+	"errors"
+	"io/fs"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Extensions of formats that are already compressed, grouped by kind for
+// building exclusion lists of your own.
 var (
-	DefaultExcludedExtentions = NewExcludedExtensions([]string{
-		".png", ".gif", ".jpeg", ".jpg",
+	ImageExtensions   = []string{".png", ".gif", ".jpeg", ".jpg", ".webp", ".avif", ".heic"}
+	ArchiveExtensions = []string{".zip", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".br", ".7z", ".rar"}
+	VideoExtensions   = []string{".mp4", ".m4v", ".webm", ".mkv", ".mov", ".avi"}
+	AudioExtensions   = []string{".mp3", ".m4a", ".aac", ".ogg", ".opus", ".flac"}
+	FontExtensions    = []string{".woff", ".woff2"}
+)
+
+var (
+	// DefaultExcludedExtentions holds the extensions of images, archives,
+	// video, audio and fonts. WebAssembly is left out as it compresses well.
+	// Add and Remove change the defaults of middleware created afterwards;
+	// every middleware keeps a copy of the extensions it was created with.
+	// They are not safe to call while middleware are being created.
+	DefaultExcludedExtentions = func() ExcludedExtensions {
+		res := make(ExcludedExtensions)
+		for _, group := range [][]string{
+			ImageExtensions, ArchiveExtensions, VideoExtensions, AudioExtensions, FontExtensions,
+		} {
+			res.Add(group...)
+		}
+		return res
+	}()
+	DefaultExcludedMethods = NewExcludedMethods([]string{
+		http.MethodHead, http.MethodOptions,
+	})
+	// DefaultExcludedContentTypes holds gRPC payloads, which carry their own
+	// framing and compression, and formats that are already compressed.
+	DefaultExcludedContentTypes = NewExcludedContentTypes([]string{
+		"application/grpc*", "application/zip", "image/webp", "video/*", "font/woff2",
+	})
+	// DefaultExcludedStatusCodes holds the statuses whose responses never
+	// carry a body. Any other status, e.g. 207 Multi-Status, is compressed.
+	DefaultExcludedStatusCodes = NewExcludedStatusCodes([]int{
+		http.StatusNoContent, http.StatusNotModified,
 	})
 	DefaultOptions = &Options{
-		ExcludedExtensions: DefaultExcludedExtentions,
+		ExcludedExtensions:   DefaultExcludedExtentions,
+		ExcludedMethods:      DefaultExcludedMethods,
+		ExcludedContentTypes: DefaultExcludedContentTypes,
+		ExcludedStatusCodes:  DefaultExcludedStatusCodes,
+		AllowWildcardAccept:  true,
 	}
 )
 
 type Options struct {
-	ExcludedExtensions   ExcludedExtensions
-	ExcludedPaths        ExcludedPaths
-	ExcludedPathesRegexs ExcludedPathesRegexs
-	DecompressFn         func(c *gin.Context)
+	ExcludedExtensions        ExcludedExtensions
+	ExcludedPaths             ExcludedPaths
+	ExcludedPathesRegexs      ExcludedPathesRegexs
+	ExcludedMethods           ExcludedMethods
+	ExcludedPathMatcher       PathMatcher
+	IncludedPaths             IncludedPaths
+	IncludedPathsRegexs       IncludedPathsRegexs
+	ExcludedRoutes            ExcludedRoutes
+	ExcludedContentTypes      ExcludedContentTypes
+	IncludedContentTypes      IncludedContentTypes
+	ExcludedStatusCodes       ExcludedStatusCodes
+	DecompressFn              func(c *gin.Context)
+	DecompressShouldFn        func(c *gin.Context) bool
+	DecompressErrorHandler    func(c *gin.Context, err error)
+	CustomShouldCompressFn    func(c *gin.Context) bool
+	ResponseShouldCompressFn  func(status int, header http.Header) bool
+	ResponseRewriter          func(header http.Header, firstChunk []byte) Decision
+	Deterministic             bool
+	PoolConfig                PoolConfig
+	HeaderOS                  *byte
+	StatsTrailers             bool
+	RejectMultistream         bool
+	LenientDecompression      bool
+	FaultInjection            *FaultInjection
+	CompressRangeRequests     bool
+	CompressUpgradeRequests   bool
+	LengthTrailer             string
+	OriginalLengthHeader      string
+	SkipHTTP10                bool
+	HTTP10BufferSize          int
+	AdaptiveMinLevel          int
+	AdaptiveMaxLevel          int
+	LoadProbe                 func() float64
+	MaxConcurrentCompressions int
+	ContentSniffing           bool
+	DecisionBudget            time.Duration
+	EventHook                 func(c *gin.Context, e Event)
+	MinLength                 int
+	PrecompressedRoot         string
+	Level                     *int
+	LevelRules                map[string]int
+	MaxDecompressedSize       int64
+	MaxDecompressionRatio     float64
+	Stats                     bool
+	FlushPolicy               FlushPolicy
+	FirstByteTimeout          time.Duration
+	AllowWildcardAccept       bool
+	StrictNegotiation         bool
+	PoolManager               *PoolManager
+	SizeObserver              func(route string, original, compressed int)
+	RequestHeaderRules        []RequestHeaderRule
+	Transcode                 bool
+	KeepAcceptRanges          bool
+	ETagVariant               bool
+	MultipartDecompression    bool
+	SkipInternal              []string
+	CaseInsensitiveMatching   bool
+	ExcludedQueryParams       ExcludedQueryParams
+	ExcludedRegexPatterns     []string
+	IncludedRegexPatterns     []string
+	PrecompressedFS           fs.FS
+	TrustedNetworks           []string
+	TrustedRequestFn          func(c *gin.Context) bool
+	PrecompressedPrefix       string
+	Dictionaries              [][]byte
 }
 
 type Option func(*Options)
 
+// WithExcludedExtensions replaces the excluded extensions, defaults
+// included, with args. Use WithAdditionalExcludedExtensions to keep them.
 func WithExcludedExtensions(args []string) Option {
 	return func(o *Options) {
 		o.ExcludedExtensions = NewExcludedExtensions(args)
 	}
 }
 
+// WithAdditionalExcludedExtensions excludes args on top of the extensions
+// already excluded, e.g. the defaults.
+func WithAdditionalExcludedExtensions(args []string) Option {
+	return func(o *Options) {
+		o.ExcludedExtensions = o.ExcludedExtensions.Clone()
+		o.ExcludedExtensions.Add(args...)
+	}
+}
+
 func WithExcludedPaths(args []string) Option {
 	return func(o *Options) {
 		o.ExcludedPaths = NewExcludedPaths(args)
 	}
 }
 
+// WithExcludedPathsRegexs excludes the request paths matching one of args.
+// The expressions are kept in ExcludedRegexPatterns and compiled by New, which
+// reports invalid ones.
 func WithExcludedPathsRegexs(args []string) Option {
 	return func(o *Options) {
-		o.ExcludedPathesRegexs = NewExcludedPathesRegexs(args)
+		o.ExcludedRegexPatterns = args
+		o.ExcludedPathesRegexs = nil
+	}
+}
+
+// WithIncludedPaths restricts compression to requests whose path starts with
+// one of paths, e.g. "/api/". Combined with WithIncludedPathsRegexs a request
+// is compressed when either list matches. Exclusions still apply.
+func WithIncludedPaths(paths []string) Option {
+	return func(o *Options) {
+		o.IncludedPaths = NewIncludedPaths(paths)
+	}
+}
+
+// WithIncludedPathsRegexs restricts compression to requests whose path matches
+// one of regexs, see WithIncludedPaths and WithExcludedPathsRegexs.
+func WithIncludedPathsRegexs(regexs []string) Option {
+	return func(o *Options) {
+		o.IncludedRegexPatterns = regexs
+		o.IncludedPathsRegexs = nil
+	}
+}
+
+// WithExcludedPathMatcher excludes the request paths m matches, for route sets
+// that are better served by a glob matcher or a radix tree than by prefixes
+// or regexes.
+func WithExcludedPathMatcher(m PathMatcher) Option {
+	return func(o *Options) {
+		o.ExcludedPathMatcher = m
+	}
+}
+
+// WithExcludedPathsFunc excludes the request paths fn returns true for.
+func WithExcludedPathsFunc(fn func(path string) bool) Option {
+	return WithExcludedPathMatcher(PathMatcherFunc(fn))
+}
+
+// WithCaseInsensitiveMatching ignores case when matching request paths
+// against ExcludedExtensions, ExcludedPaths, IncludedPaths and the path
+// regexes, so "/IMAGE.PNG" is excluded like "/image.png". Extensions, path
+// prefixes and paths are lowercased before matching and the regexes match
+// ignoring case. ExcludedPathMatcher still gets the path as it is.
+func WithCaseInsensitiveMatching() Option {
+	return func(o *Options) {
+		o.CaseInsensitiveMatching = true
+	}
+}
+
+// compileRegexs compiles the expressions given to WithExcludedPathsRegexs
+// and WithIncludedPathsRegexs, reporting every invalid one, and any path
+// regex in gzipminimal builds.
+func (o *Options) compileRegexs() error {
+	var errs []error
+	if o.ExcludedRegexPatterns != nil {
+		regexs, err := compileRegexs("excluded", o.ExcludedRegexPatterns)
+		o.ExcludedPathesRegexs = regexs
+		o.ExcludedRegexPatterns = nil
+		errs = append(errs, err)
+	}
+	if o.IncludedRegexPatterns != nil {
+		regexs, err := compileRegexs("included", o.IncludedRegexPatterns)
+		o.IncludedPathsRegexs = regexs
+		o.IncludedRegexPatterns = nil
+		errs = append(errs, err)
+	}
+	if minimalBuild && (o.ExcludedPathesRegexs != nil || o.IncludedPathsRegexs != nil) {
+		errs = append(errs, errors.New("gzip: path regexes are not available in gzipminimal builds"))
+	}
+	return errors.Join(errs...)
+}
+
+// foldCase lowercases the extensions and path prefixes of o and makes its
+// regexes case-insensitive, see WithCaseInsensitiveMatching. The lists are
+// replaced rather than changed, as they may be shared with other Options.
+func (o *Options) foldCase() {
+	extensions := make(ExcludedExtensions, len(o.ExcludedExtensions))
+	for ext := range o.ExcludedExtensions {
+		extensions[strings.ToLower(ext)] = struct{}{}
+	}
+	o.ExcludedExtensions = extensions
+	o.ExcludedPaths = lowerAll(o.ExcludedPaths)
+	o.IncludedPaths = lowerAll(o.IncludedPaths)
+	o.ExcludedPathesRegexs = foldRegexs(o.ExcludedPathesRegexs)
+	o.IncludedPathsRegexs = foldRegexs(o.IncludedPathsRegexs)
+}
+
+func lowerAll[S ~[]string](s S) S {
+	if s == nil {
+		return nil
+	}
+	res := make(S, len(s))
+	for i, v := range s {
+		res[i] = strings.ToLower(v)
+	}
+	return res
+}
+
+// WithExcludedQueryParams excludes requests by their query string: a request
+// is excluded when it carries one of the parameters of params with one of its
+// values, e.g. {"download": {"1", "true"}} excludes "/report?download=1". A
+// parameter listed without values excludes whatever its value.
+func WithExcludedQueryParams(params map[string][]string) Option {
+	return func(o *Options) {
+		o.ExcludedQueryParams = NewExcludedQueryParams(params)
+	}
+}
+
+// WithExcludedRoutes excludes requests by the route they matched, as returned
+// by gin.Context.FullPath, so "/users/:id/avatar" excludes every user's avatar.
+func WithExcludedRoutes(routes []string) Option {
+	return func(o *Options) {
+		o.ExcludedRoutes = NewExcludedRoutes(routes)
+	}
+}
+
+// WithExcludedContentTypes replaces the response content types that are never
+// compressed, see ExcludedContentTypes. By default gRPC and already
+// compressed formats are skipped, see DefaultExcludedContentTypes.
+func WithExcludedContentTypes(contentTypes []string) Option {
+	return func(o *Options) {
+		o.ExcludedContentTypes = NewExcludedContentTypes(contentTypes)
+	}
+}
+
+// WithIncludedContentTypes restricts compression to responses whose content
+// type matches one of contentTypes, in the syntax of ExcludedContentTypes.
+// Responses without a Content-Type are then left uncompressed.
+func WithIncludedContentTypes(contentTypes []string) Option {
+	return func(o *Options) {
+		o.IncludedContentTypes = NewIncludedContentTypes(contentTypes)
+	}
+}
+
+// WithExcludedStatusCodes replaces the response statuses that are never
+// compressed, see DefaultExcludedStatusCodes. Partial content is governed by
+// WithCompressRangeRequests instead.
+func WithExcludedStatusCodes(codes []int) Option {
+	return func(o *Options) {
+		o.ExcludedStatusCodes = NewExcludedStatusCodes(codes)
+	}
+}
+
+// WithExcludedMethods replaces the request methods whose responses are never
+// compressed. By default HEAD and OPTIONS are skipped.
+func WithExcludedMethods(args []string) Option {
+	return func(o *Options) {
+		o.ExcludedMethods = NewExcludedMethods(args)
+	}
+}
+
+// WithAllowWildcardAccept sets whether "*" in Accept-Encoding, which permits
+// any coding not listed otherwise, lets responses be compressed. It does by
+// default; "gzip;q=0" forbids gzip either way.
+func WithAllowWildcardAccept(allow bool) Option {
+	return func(o *Options) {
+		o.AllowWildcardAccept = allow
+	}
+}
+
+// WithStrictNegotiation answers 406 Not Acceptable, as RFC 9110 allows, to
+// requests that accept neither gzip nor an unencoded response, e.g. with
+// "Accept-Encoding: br, identity;q=0", instead of sending the body unencoded.
+func WithStrictNegotiation() Option {
+	return func(o *Options) {
+		o.StrictNegotiation = true
+	}
+}
+
+// WithCompressRangeRequests controls whether requests carrying a Range header
+// and 206 Partial Content responses are compressed. They are not by default,
+// since compression breaks the byte offsets the client asked for.
+func WithCompressRangeRequests(compress bool) Option {
+	return func(o *Options) {
+		o.CompressRangeRequests = compress
+	}
+}
+
+// WithETagVariant appends the content coding to the ETag of compressed
+// responses, e.g. "abc" becomes "abc-gzip", so shared caches keyed by ETag
+// keep the compressed and identity representations apart. The suffix is
+// removed from If-None-Match and If-Match before the handler sees them, and
+// put back on the ETag of the 304 Not Modified answering them.
+func WithETagVariant() Option {
+	return func(o *Options) {
+		o.ETagVariant = true
+	}
+}
+
+// WithKeepAcceptRanges leaves the Accept-Ranges header of compressed
+// responses alone. By default it becomes "none", since the byte ranges a
+// handler such as http.ServeContent advertises are those of the uncompressed
+// body, which range requests get, and not of the body the client received.
+func WithKeepAcceptRanges() Option {
+	return func(o *Options) {
+		o.KeepAcceptRanges = true
+	}
+}
+
+// WithUpgradeBypass controls whether requests asking for a protocol upgrade,
+// such as WebSocket handshakes, bypass the middleware. They do by default,
+// since a compressed handshake response breaks the upgrade.
+func WithUpgradeBypass(bypass bool) Option {
+	return func(o *Options) {
+		o.CompressUpgradeRequests = !bypass
 	}
 }
 
@@ -51,6 +372,457 @@ func WithDecompressFn(decompressFn func(c *gin.Context)) Option {
 	}
 }
 
+// WithDecompressShouldFn restricts request decompression: DecompressFn only
+// runs for gzip-encoded requests for which fn returns true.
+func WithDecompressShouldFn(fn func(c *gin.Context) bool) Option {
+	return func(o *Options) {
+		o.DecompressShouldFn = fn
+	}
+}
+
+// WithDecompressErrorHandler replaces the bare 400 Bad Request sent when a
+// request's Content-Encoding cannot be parsed or its body does not start with
+// a valid gzip header, e.g. to write an API error envelope. When fn does not
+// abort the context the request goes on with its body left as sent.
+// Failures while the handler reads the body are returned by the reads.
+func WithDecompressErrorHandler(fn func(c *gin.Context, err error)) Option {
+	return func(o *Options) {
+		o.DecompressErrorHandler = fn
+	}
+}
+
+// WithLenientDecompression makes DefaultDecompressHandle pass request bodies
+// that do not start with the gzip magic bytes through unmodified, for clients
+// that send Content-Encoding: gzip with a plain body.
+func WithLenientDecompression() Option {
+	return func(o *Options) {
+		o.LenientDecompression = true
+	}
+}
+
+// WithRejectMultistream makes DefaultDecompressHandle fail reads with
+// ErrMultistream when a request body holds more than one gzip member, for
+// strict APIs. By default concatenated members are read as one stream.
+func WithRejectMultistream() Option {
+	return func(o *Options) {
+		o.RejectMultistream = true
+	}
+}
+
+// WithRequestHeaderRules leaves the responses to requests matching one of
+// rules uncompressed, e.g. clients known to mishandle gzip by their
+// User-Agent or internal callers by a header they send. Rules add up over
+// several calls. An invalid expression makes Gzip panic and New fail.
+func WithRequestHeaderRules(rules ...RequestHeaderRule) Option {
+	return func(o *Options) {
+		o.RequestHeaderRules = append(o.RequestHeaderRules, rules...)
+	}
+}
+
+// WithTrustedNetworks lets requests coming from one of the networks cidrs see
+// the statistics WithStatsTrailers reports, which are sent to no one otherwise
+// so the internals of the service are not published. Requests are matched by
+// c.ClientIP(), which believes the X-Forwarded-For of any peer until the
+// proxies in front of the server are listed with gin.Engine.SetTrustedProxies.
+// Networks add up over several calls. An invalid CIDR makes Gzip panic and New
+// fail.
+func WithTrustedNetworks(cidrs []string) Option {
+	return func(o *Options) {
+		o.TrustedNetworks = append(o.TrustedNetworks, cidrs...)
+	}
+}
+
+// WithTrustedRequest trusts the requests fn returns true for, besides the
+// ones WithTrustedNetworks trusts, e.g. requests carrying a debug header with
+// a shared secret.
+func WithTrustedRequest(fn func(c *gin.Context) bool) Option {
+	return func(o *Options) {
+		o.TrustedRequestFn = fn
+	}
+}
+
+// WithSkipInternal leaves the responses to requests coming from one of the
+// networks cidrs, e.g. "10.0.0.0/8" or "::1/128", uncompressed, saving CPU on
+// health checks and sidecars where bandwidth is free. Requests are matched by
+// the address of the connection, so a proxy in front of the server is matched
+// rather than its clients. Networks add up over several calls. An invalid
+// CIDR makes Gzip panic and New fail.
+func WithSkipInternal(cidrs []string) Option {
+	return func(o *Options) {
+		o.SkipInternal = append(o.SkipInternal, cidrs...)
+	}
+}
+
+// WithTranscode decodes responses a handler, typically a reverse proxy,
+// sends gzip-encoded when the client does not accept gzip, e.g. curl without
+// --compressed, instead of passing the encoding through. Clients accepting
+// gzip get the encoded body as it is.
+func WithTranscode() Option {
+	return func(o *Options) {
+		o.Transcode = true
+	}
+}
+
+// WithNoCompressionHeader leaves the responses to requests carrying header
+// name, e.g. "X-No-Compression", uncompressed, whatever its value, for
+// debugging and for callers that cannot handle compressed bodies.
+func WithNoCompressionHeader(name string) Option {
+	return WithRequestHeaderRules(RequestHeaderRule{Name: name})
+}
+
+// WithBrokenProxies leaves the responses to requests that passed one of
+// proxies uncompressed, for intermediaries known to mangle compressed bodies.
+// A proxy matches when its name appears, ignoring case, in the Via header,
+// which takes a request header rule value, so gzipminimal builds reject it.
+func WithBrokenProxies(proxies ...string) Option {
+	rules := make([]RequestHeaderRule, len(proxies))
+	for i, proxy := range proxies {
+		rules[i] = RequestHeaderRule{Name: "Via", Value: "(?i)" + regexp.QuoteMeta(proxy)}
+	}
+	return WithRequestHeaderRules(rules...)
+}
+
+// WithCustomShouldCompressFn adds a request-time check run after the built-in
+// exclusions; returning false serves the response uncompressed.
+func WithCustomShouldCompressFn(fn func(c *gin.Context) bool) Option {
+	return func(o *Options) {
+		o.CustomShouldCompressFn = fn
+	}
+}
+
+// WithResponseShouldCompressFn adds a response-time check evaluated once, on
+// the first write, with the final status code and headers set by the handler.
+// Returning false serves the response uncompressed.
+func WithResponseShouldCompressFn(fn func(status int, header http.Header) bool) Option {
+	return func(o *Options) {
+		o.ResponseShouldCompressFn = fn
+	}
+}
+
+// WithResponseRewriter adds a response-time hook called once, on the first
+// write, with the headers set by the handler and the first bytes of the body,
+// for decisions the filters cannot express, e.g. detecting base64 blobs. It
+// runs after the checks for bodiless and already encoded responses, may
+// change the headers, and returns DecisionSkip, DecisionCompress to skip the
+// remaining checks such as MinLength, or DecisionDefault. firstChunk is nil
+// when the handler flushed before writing, and must not be retained.
+func WithResponseRewriter(fn func(header http.Header, firstChunk []byte) Decision) Option {
+	return func(o *Options) {
+		o.ResponseRewriter = fn
+	}
+}
+
+// WithDecisionBudget bounds the time ResponseShouldCompressFn may take on the
+// first write. A slower call is abandoned and the response is compressed as
+// if the function had returned true. The function then runs on its own
+// goroutine with a copy of the header.
+func WithDecisionBudget(budget time.Duration) Option {
+	return func(o *Options) {
+		o.DecisionBudget = budget
+	}
+}
+
+// WithEventHook sets fn to be called whenever a response is left uncompressed,
+// with the reason, and whenever a gzip request body fails to decompress. It
+// runs on the request goroutine and should return quickly.
+func WithEventHook(fn func(c *gin.Context, e Event)) Option {
+	return func(o *Options) {
+		o.EventHook = fn
+	}
+}
+
+// WithMinLength leaves bodies shorter than n bytes uncompressed, since gzip
+// framing makes them larger. A Content-Length set before the first write
+// decides at once and is kept on skipped responses. Without one the start of
+// the body is held back until n bytes are written, the handler returns or
+// flushes.
+func WithMinLength(n int) Option {
+	return func(o *Options) {
+		o.MinLength = n
+	}
+}
+
+// WithLevel overrides the level passed to Gzip or New, for option bundles.
+func WithLevel(level int) Option {
+	return func(o *Options) {
+		o.Level = &level
+	}
+}
+
+// WithLevelRules compresses the requests matching a pattern at its level
+// instead of the middleware's own, e.g. {"/api/*": BestSpeed}. Patterns are
+// compared with both the matched route, as returned by c.FullPath, and the
+// request path; a trailing "*" matches by prefix and the longest matching
+// pattern wins.
+func WithLevelRules(rules map[string]int) Option {
+	return func(o *Options) {
+		o.LevelRules = rules
+	}
+}
+
+// WithMaxDecompressedSize makes DefaultDecompressHandle fail reads of request
+// bodies that decompress to more than n bytes with an *http.MaxBytesError,
+// guarding against decompression bombs.
+func WithMaxDecompressedSize(n int64) Option {
+	return func(o *Options) {
+		o.MaxDecompressedSize = n
+	}
+}
+
+// WithMaxDecompressionRatio makes DefaultDecompressHandle fail reads of
+// request bodies once they decompress to more than ratio times the bytes
+// received, e.g. 100, which stops crafted decompression bombs early while
+// allowing large bodies that compress normally. The first 64 KiB are exempt.
+// The failure is reported like an invalid body, with a 400 Bad Request
+// unless WithDecompressErrorHandler is set, and reads keep returning
+// ErrDecompressionRatio.
+func WithMaxDecompressionRatio(ratio float64) Option {
+	return func(o *Options) {
+		o.MaxDecompressionRatio = ratio
+	}
+}
+
+// WithMultipartDecompression decodes the parts of multipart request bodies,
+// e.g. file uploads, that carry their own Content-Encoding: gzip, as the body
+// is read. The parts lose that header, and MaxDecompressedSize applies to
+// each of them. It works with or without DecompressFn.
+func WithMultipartDecompression() Option {
+	return func(o *Options) {
+		o.MultipartDecompression = true
+	}
+}
+
+// WithStats records compressed responses, skip reasons and decompression
+// failures in process-wide counters, see ReadStats and StatsHandler. The
+// counters stay empty in gzipminimal builds.
+func WithStats() Option {
+	return func(o *Options) {
+		o.Stats = true
+	}
+}
+
+// WithSizeObserver calls fn for every compressed response once the gzip
+// stream is closed, with the route as gin reports it by c.FullPath and the
+// body size before and after compression, for dashboards of the savings per
+// route without a metrics dependency. See WithStats for built-in counters.
+func WithSizeObserver(fn func(route string, original, compressed int)) Option {
+	return func(o *Options) {
+		o.SizeObserver = fn
+	}
+}
+
+// WithPrecompressedFiles serves the sibling root/<path>.gz of a requested file,
+// when present, to clients accepting gzip. Only GET and HEAD requests that no
+// route matches are looked up, so registered handlers always run, unless
+// their path starts with the prefix set by WithPrecompressedPrefix. The
+// Content-Type is taken from the original file name. Gzipminimal builds
+// reject it.
+func WithPrecompressedFiles(root string) Option {
+	return func(o *Options) {
+		WithPrecompressedFS(os.DirFS(root))(o)
+		o.PrecompressedRoot = root
+	}
+}
+
+// WithPrecompressedFS is WithPrecompressedFiles looking up the <path>.gz
+// siblings in fsys, e.g. an embed.FS.
+func WithPrecompressedFS(fsys fs.FS) Option {
+	return func(o *Options) {
+		o.PrecompressedRoot = ""
+		o.PrecompressedFS = fsys
+	}
+}
+
+// WithPrecompressedPrefix also looks up the precompressed siblings of GET and
+// HEAD requests whose path starts with prefix, e.g. "/assets/", in front of
+// the handlers serving them, such as a RouterGroup.Static.
+func WithPrecompressedPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.PrecompressedPrefix = prefix
+	}
+}
+
+// WithDictionaries serves Compression Dictionary Transport: a request that
+// accepts dcz and names the SHA-256 of one of dicts in Available-Dictionary
+// gets its response compressed with zstd against that dictionary, often a
+// fraction of the gzip size when a new version of a script replaces the
+// cached one. Other requests get gzip as before. A dictionary must be, byte
+// for byte, a response the client stored because it carried a
+// Use-As-Dictionary header, which the handler serving it sets. Dictionaries
+// add up over several calls. They take the zstd encoder of the klauspost
+// backend: other builds make Gzip panic and New fail.
+func WithDictionaries(dicts ...[]byte) Option {
+	return func(o *Options) {
+		o.Dictionaries = append(o.Dictionaries, dicts...)
+	}
+}
+
+// WithDeterministic pins every field of the gzip header (zero ModTime, no
+// name or comment, unknown OS) so identical bodies always produce identical
+// compressed bytes for a given level and Go version.
+func WithDeterministic() Option {
+	return func(o *Options) {
+		o.Deterministic = true
+	}
+}
+
+// WithHeaderOS forces the OS byte of the gzip header, e.g. 3 for Unix or 255
+// for unknown, so binaries built for different platforms emit identical
+// streams for the same input.
+func WithHeaderOS(os byte) Option {
+	return func(o *Options) {
+		o.HeaderOS = &os
+	}
+}
+
+// WithStatsTrailers declares the X-Original-Size and X-Compression-Ratio
+// trailers on compressed responses and fills them in once the body has been
+// fully written, so they reflect final values without buffering the response.
+// They are only sent to trusted requests, see WithTrustedNetworks.
+func WithStatsTrailers() Option {
+	return func(o *Options) {
+		o.StatsTrailers = true
+	}
+}
+
+// WithLengthTrailer declares a trailer named name on compressed responses
+// carrying the length of the compressed body, so clients of streamed responses
+// sent without Content-Length can still learn their size. Content-Length
+// itself is not allowed in trailers; it is replaced by X-Content-Length.
+func WithLengthTrailer(name string) Option {
+	return func(o *Options) {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Content-Length" {
+			debugPrintWARNING("Content-Length cannot be sent as a trailer, using %s instead", headerContentLengthTrailer)
+			name = headerContentLengthTrailer
+		}
+		o.LengthTrailer = name
+	}
+}
+
+// WithOriginalLengthHeader reports the uncompressed length of compressed
+// responses under name, e.g. X-Uncompressed-Content-Length, for download
+// progress bars. It is sent as a header when the whole compressed body is
+// still buffered at the end of the request (see WithPoolConfig) and as a
+// trailer otherwise, to every client.
+func WithOriginalLengthHeader(name string) Option {
+	return func(o *Options) {
+		o.OriginalLengthHeader = http.CanonicalHeaderKey(name)
+	}
+}
+
+// WithBuffering holds up to maxBytes of compressed output per response before
+// sending anything, so responses that fit are sent with an exact
+// Content-Length instead of chunked transfer encoding, which some clients and
+// CDNs require. Larger responses, and handlers that Flush, are streamed once
+// the buffer fills. It sets the buffer size of WithPoolConfig.
+func WithBuffering(maxBytes int) Option {
+	return func(o *Options) {
+		o.PoolConfig.BufferSize = maxBytes
+	}
+}
+
+// WithSkipHTTP10 leaves responses to HTTP/1.0 requests uncompressed. Such
+// clients cannot receive chunked bodies, so a compressed stream of unknown
+// length can only be delimited by closing the connection.
+func WithSkipHTTP10() Option {
+	return func(o *Options) {
+		o.SkipHTTP10 = true
+	}
+}
+
+// WithHTTP10Buffering buffers up to maxBytes of compressed output for HTTP/1.0
+// requests, like WithBuffering does for every request, so those responses are
+// sent with a Content-Length. Larger responses are still streamed and end
+// when the connection closes.
+func WithHTTP10Buffering(maxBytes int) Option {
+	return func(o *Options) {
+		o.HTTP10BufferSize = maxBytes
+	}
+}
+
+// WithAdaptiveLevel picks the compression level of each response between
+// minLevel and maxLevel from the current load, dropping towards minLevel under
+// pressure and returning to maxLevel when idle. The level given to Gzip is
+// ignored. Load is measured as the number of responses being compressed per
+// CPU unless WithLoadProbe supplies another measure.
+func WithAdaptiveLevel(minLevel, maxLevel int) Option {
+	return func(o *Options) {
+		o.AdaptiveMinLevel = minLevel
+		o.AdaptiveMaxLevel = maxLevel
+	}
+}
+
+// WithLoadProbe replaces the load measure of WithAdaptiveLevel. probe returns
+// the load as a value between 0 (idle) and 1 (saturated).
+func WithLoadProbe(probe func() float64) Option {
+	return func(o *Options) {
+		o.LoadProbe = probe
+	}
+}
+
+// WithMaxConcurrentCompressions caps the number of responses compressed at
+// the same time to n. Requests beyond the cap are served uncompressed instead
+// of waiting, which protects tail latency on busy multi-tenant gateways.
+func WithMaxConcurrentCompressions(n int) Option {
+	return func(o *Options) {
+		o.MaxConcurrentCompressions = n
+	}
+}
+
+// WithContentSniffing inspects the start of the body for the signatures of
+// formats that are already compressed, such as PNG, JPEG, ZIP, MP4, PDF and
+// gzip itself, and leaves those responses uncompressed even when no path,
+// extension or content type filter catches them. Only the first chunk the
+// handler writes is inspected, and nothing is sniffed when the headers are
+// flushed before any data.
+func WithContentSniffing() Option {
+	return func(o *Options) {
+		o.ContentSniffing = true
+	}
+}
+
+// WithFlushPolicy sets what Flush does to the gzip stream: FlushImmediate,
+// the default, FlushBuffered or FlushInterval.
+func WithFlushPolicy(policy FlushPolicy) Option {
+	return func(o *Options) {
+		o.FlushPolicy = policy
+	}
+}
+
+// WithFirstByteTimeout flushes the compressed stream once when the handler
+// has not flushed within timeout of its first write, so slow handlers, e.g.
+// progressively rendered HTML pages, get their first bytes out early. The
+// timer starts on the first write and flushes while the handler is busy, so
+// the headers are sent on that write.
+func WithFirstByteTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.FirstByteTimeout = timeout
+	}
+}
+
+// WithPoolConfig pre-warms the writer pool with initialSize writers, keeps at
+// most maxWriters idle writers around (0 for no limit) and, when bufferSize is
+// positive, buffers compressed output in chunks of that size.
+func WithPoolConfig(initialSize, maxWriters, bufferSize int) Option {
+	return func(o *Options) {
+		o.PoolConfig = PoolConfig{
+			InitialSize: initialSize,
+			MaxWriters:  maxWriters,
+			BufferSize:  bufferSize,
+		}
+	}
+}
+
+// WithPoolManager takes the gzip writers from m instead of pools of the
+// middleware's own, so middleware instances sharing m share their writers.
+// The pools of m are configured by NewPoolManager, PoolConfig is ignored.
+func WithPoolManager(m *PoolManager) Option {
+	return func(o *Options) {
+		o.PoolManager = m
+	}
+}
+
 // Using map for better lookup performance
 type ExcludedExtensions map[string]struct{}
 
@@ -67,6 +839,161 @@ func (e ExcludedExtensions) Contains(target string) bool {
 	return ok
 }
 
+// Add excludes extensions, e.g. ".pdf".
+func (e ExcludedExtensions) Add(extensions ...string) {
+	for _, ext := range extensions {
+		e[ext] = struct{}{}
+	}
+}
+
+// Remove stops excluding extensions.
+func (e ExcludedExtensions) Remove(extensions ...string) {
+	for _, ext := range extensions {
+		delete(e, ext)
+	}
+}
+
+// Clone returns a copy of e, which may be changed without affecting e.
+func (e ExcludedExtensions) Clone() ExcludedExtensions {
+	res := make(ExcludedExtensions, len(e))
+	for ext := range e {
+		res[ext] = struct{}{}
+	}
+	return res
+}
+
+type ExcludedMethods map[string]struct{}
+
+func NewExcludedMethods(methods []string) ExcludedMethods {
+	res := make(ExcludedMethods)
+	for _, m := range methods {
+		res[strings.ToUpper(m)] = struct{}{}
+	}
+	return res
+}
+
+func (e ExcludedMethods) Contains(method string) bool {
+	_, ok := e[method]
+	return ok
+}
+
+// ExcludedContentTypes matches the media type of a Content-Type header value,
+// ignoring parameters and case. Patterns ending in "*" match by prefix, so
+// "video/*" matches every video type.
+type ExcludedContentTypes []string
+
+func NewExcludedContentTypes(contentTypes []string) ExcludedContentTypes {
+	res := make(ExcludedContentTypes, len(contentTypes))
+	for i, t := range contentTypes {
+		res[i] = strings.ToLower(strings.TrimSpace(t))
+	}
+	return res
+}
+
+func (e ExcludedContentTypes) Contains(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if mediaType == "" {
+		return false
+	}
+	// the patterns are lowercased once, so comparing with EqualFold saves
+	// lowercasing every response's content type
+	for _, t := range e {
+		if prefix, ok := strings.CutSuffix(t, "*"); ok {
+			if len(mediaType) >= len(prefix) && strings.EqualFold(mediaType[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// IncludedContentTypes matches content types like ExcludedContentTypes.
+type IncludedContentTypes []string
+
+func NewIncludedContentTypes(contentTypes []string) IncludedContentTypes {
+	return IncludedContentTypes(NewExcludedContentTypes(contentTypes))
+}
+
+func (i IncludedContentTypes) Contains(contentType string) bool {
+	return ExcludedContentTypes(i).Contains(contentType)
+}
+
+type ExcludedRoutes map[string]struct{}
+
+func NewExcludedRoutes(routes []string) ExcludedRoutes {
+	res := make(ExcludedRoutes)
+	for _, r := range routes {
+		res[r] = struct{}{}
+	}
+	return res
+}
+
+func (e ExcludedRoutes) Contains(fullPath string) bool {
+	_, ok := e[fullPath]
+	return ok
+}
+
+type ExcludedStatusCodes map[int]struct{}
+
+func NewExcludedStatusCodes(codes []int) ExcludedStatusCodes {
+	res := make(ExcludedStatusCodes)
+	for _, code := range codes {
+		res[code] = struct{}{}
+	}
+	return res
+}
+
+func (e ExcludedStatusCodes) Contains(code int) bool {
+	_, ok := e[code]
+	return ok
+}
+
+// ExcludedQueryParams maps query parameters to the values that exclude a
+// request, any value for a parameter without values.
+type ExcludedQueryParams map[string][]string
+
+func NewExcludedQueryParams(params map[string][]string) ExcludedQueryParams {
+	res := make(ExcludedQueryParams, len(params))
+	for name, values := range params {
+		res[name] = append([]string(nil), values...)
+	}
+	return res
+}
+
+func (e ExcludedQueryParams) Contains(query url.Values) bool {
+	for name, values := range e {
+		got, ok := query[name]
+		if !ok {
+			continue
+		}
+		if len(values) == 0 {
+			return true
+		}
+		for _, v := range got {
+			if slices.Contains(values, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PathMatcher reports whether a request path is matched. ExcludedPaths and
+// ExcludedPathesRegexs implement it.
+type PathMatcher interface {
+	Contains(path string) bool
+}
+
+// PathMatcherFunc adapts an ordinary function to PathMatcher.
+type PathMatcherFunc func(path string) bool
+
+func (f PathMatcherFunc) Contains(path string) bool {
+	return f(path)
+}
+
 type ExcludedPaths []string
 
 func NewExcludedPaths(paths []string) ExcludedPaths {
@@ -82,35 +1009,35 @@ func (e ExcludedPaths) Contains(requestURI string) bool {
 	return false
 }
 
+// IncludedPaths matches request paths by prefix, like ExcludedPaths.
+type IncludedPaths []string
+
+func NewIncludedPaths(paths []string) IncludedPaths {
+	return IncludedPaths(paths)
+}
+
+func (i IncludedPaths) Contains(requestURI string) bool {
+	return ExcludedPaths(i).Contains(requestURI)
+}
+
+// IncludedPathsRegexs matches request paths by regular expression, like
+// ExcludedPathesRegexs.
+type IncludedPathsRegexs []*regexp.Regexp
+
+func NewIncludedPathsRegexs(regexs []string) IncludedPathsRegexs {
+	return IncludedPathsRegexs(NewExcludedPathesRegexs(regexs))
+}
+
+func (i IncludedPathsRegexs) Contains(requestURI string) bool {
+	return ExcludedPathesRegexs(i).Contains(requestURI)
+}
+
 type ExcludedPathesRegexs []*regexp.Regexp
 
 func NewExcludedPathesRegexs(regexs []string) ExcludedPathesRegexs {
-	result := make([]*regexp.Regexp, len(regexs))
-	for i, reg := range regexs {
-		result[i] = regexp.MustCompile(reg)
-	}
-	return result
+	return mustCompileRegexs(regexs)
 }
 
 func (e ExcludedPathesRegexs) Contains(requestURI string) bool {
-	for _, reg := range e {
-		if reg.MatchString(requestURI) {
-			return true
-		}
-	}
-	return false
-}
-
-func DefaultDecompressHandle(c *gin.Context) {
-	if c.Request.Body == nil {
-		return
-	}
-	r, err := gzip.NewReader(c.Request.Body)
-	if err != nil {
-		_ = c.AbortWithError(http.StatusBadRequest, err)
-		return
-	}
-	c.Request.Header.Del("Content-Encoding")
-	c.Request.Header.Del("Content-Length")
-	c.Request.Body = r
+	return matchRegexs(e, requestURI)
 }
@@ -0,0 +1,60 @@
+//go:build !gzipminimal
+
+package gzip
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileRegexs compiles exprs, reporting every invalid one.
+func compileRegexs(kind string, exprs []string) ([]*regexp.Regexp, error) {
+	var regexs []*regexp.Regexp
+	var errs []error
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("gzip: %s path regex: %w", kind, err))
+			continue
+		}
+		regexs = append(regexs, re)
+	}
+	return regexs, errors.Join(errs...)
+}
+
+// mustCompileRegexs compiles exprs, panicking on the first invalid one.
+func mustCompileRegexs(exprs []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range exprs {
+		res[i] = regexp.MustCompile(expr)
+	}
+	return res
+}
+
+// matchRegexs reports whether one of regexs matches s.
+func matchRegexs(regexs []*regexp.Regexp, s string) bool {
+	for _, re := range regexs {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func foldRegexs[S ~[]*regexp.Regexp](s S) S {
+	if s == nil {
+		return nil
+	}
+	res := make(S, len(s))
+	for i, re := range s {
+		if strings.HasPrefix(re.String(), "(?i)") {
+			res[i] = re
+		} else {
+			// a valid expression stays valid with the flag
+			res[i] = regexp.MustCompile("(?i)" + re.String())
+		}
+	}
+	return res
+}
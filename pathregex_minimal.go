@@ -0,0 +1,31 @@
+//go:build gzipminimal
+
+package gzip
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compileRegexs reports any of exprs: gzipminimal builds do not match path
+// regexes.
+func compileRegexs(kind string, exprs []string) ([]*regexp.Regexp, error) {
+	if len(exprs) > 0 {
+		return nil, fmt.Errorf("gzip: %s path regexes are not available in gzipminimal builds", kind)
+	}
+	return nil, nil
+}
+
+func mustCompileRegexs([]string) []*regexp.Regexp {
+	panic("gzip: path regexes are not available in gzipminimal builds")
+}
+
+// matchRegexs matches nothing; New rejects path regexes in gzipminimal
+// builds.
+func matchRegexs([]*regexp.Regexp, string) bool {
+	return false
+}
+
+func foldRegexs[S ~[]*regexp.Regexp](s S) S {
+	return s
+}
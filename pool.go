@@ -0,0 +1,194 @@
+package gzip
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// PoolConfig tunes how gzip writers are allocated and reused.
+type PoolConfig struct {
+	// InitialSize is the number of writers allocated up front.
+	InitialSize int `json:"initial_size,omitempty" yaml:"initial_size,omitempty"`
+	// MaxWriters caps the number of idle writers kept for reuse. Writers
+	// beyond the cap are allocated on demand and dropped when released.
+	// Zero leaves the pool unbounded.
+	MaxWriters int `json:"max_writers,omitempty" yaml:"max_writers,omitempty"`
+	// BufferSize, when positive, buffers the compressed output so it reaches
+	// the response writer in chunks of this size instead of many small writes.
+	BufferSize int `json:"buffer_size,omitempty" yaml:"buffer_size,omitempty"`
+}
+
+// encoder is the stream a pooledWriter compresses into: a gzip writer, or a
+// dictionary encoder for the pools of Options.Dictionaries.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pooledWriter is a compressor with its optional output buffer. gzip is the
+// encoder of gzip writers, whose header can be set, and nil otherwise.
+type pooledWriter struct {
+	encoder
+	gzip  *compressor
+	buf   *bufio.Writer
+	inUse bool
+}
+
+func (w *pooledWriter) Reset(dst io.Writer) {
+	if w.buf == nil {
+		w.encoder.Reset(dst)
+		return
+	}
+	w.buf.Reset(dst)
+	w.encoder.Reset(w.buf)
+}
+
+func (w *pooledWriter) Flush() error {
+	if err := w.encoder.Flush(); err != nil {
+		return err
+	}
+	if w.buf != nil {
+		return w.buf.Flush()
+	}
+	return nil
+}
+
+// Buffered returns the number of compressed bytes held in the output buffer.
+func (w *pooledWriter) Buffered() int {
+	if w.buf == nil {
+		return 0
+	}
+	return w.buf.Buffered()
+}
+
+// FlushBuffer writes the buffered compressed output to the response.
+func (w *pooledWriter) FlushBuffer() error {
+	if w.buf == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+type writerPool struct {
+	level      int
+	dict       *dictionary // nil for gzip writers
+	bufferSize int
+	idle       chan *pooledWriter
+	pool       sync.Pool
+	// closed drops released writers, see PoolManager.Close.
+	closed atomic.Bool
+}
+
+func newWriterPool(level int, cfg PoolConfig) *writerPool {
+	return newDictionaryPool(level, cfg, nil)
+}
+
+// newDictionaryPool is newWriterPool for writers compressing with dict, or
+// gzip writers when it is nil.
+func newDictionaryPool(level int, cfg PoolConfig, dict *dictionary) *writerPool {
+	p := &writerPool{
+		level:      level,
+		dict:       dict,
+		bufferSize: cfg.BufferSize,
+	}
+	p.pool.New = func() interface{} {
+		return p.newWriter()
+	}
+	if cfg.MaxWriters > 0 {
+		p.idle = make(chan *pooledWriter, cfg.MaxWriters)
+	}
+	for i := 0; i < cfg.InitialSize; i++ {
+		p.release(p.newWriter())
+	}
+	return p
+}
+
+func (p *writerPool) newWriter() *pooledWriter {
+	w := &pooledWriter{}
+	if p.dict != nil {
+		w.encoder = p.dict.newEncoder(p.level)
+	} else {
+		gz, err := newCompressor(io.Discard, p.level)
+		if err != nil {
+			panic(err)
+		}
+		w.encoder, w.gzip = gz, gz
+	}
+	if p.bufferSize > 0 {
+		w.buf = bufio.NewWriterSize(io.Discard, p.bufferSize)
+	}
+	return w
+}
+
+func (p *writerPool) get() *pooledWriter {
+	w := p.take()
+	w.inUse = true
+	outstandingWriters.Add(1)
+	return w
+}
+
+func (p *writerPool) take() *pooledWriter {
+	if p.closed.Load() {
+		return p.newWriter()
+	}
+	if p.idle == nil {
+		return p.pool.Get().(*pooledWriter)
+	}
+	select {
+	case w := <-p.idle:
+		return w
+	default:
+		return p.newWriter()
+	}
+}
+
+func (p *writerPool) put(w *pooledWriter) {
+	if p.checkIn(w) {
+		p.release(w)
+	}
+}
+
+// discard checks w in without keeping it, for writers whose state is unknown
+// after a panic interrupted them.
+func (p *writerPool) discard(w *pooledWriter) {
+	p.checkIn(w)
+}
+
+// checkIn marks w as no longer in use, reporting false if it was not.
+func (p *writerPool) checkIn(w *pooledWriter) bool {
+	if !w.inUse {
+		violation("gzip writer returned to the pool twice")
+		return false
+	}
+	w.inUse = false
+	outstandingWriters.Add(-1)
+	return true
+}
+
+func (p *writerPool) release(w *pooledWriter) {
+	if p.closed.Load() {
+		return
+	}
+	if p.idle == nil {
+		p.pool.Put(w)
+		return
+	}
+	select {
+	case p.idle <- w:
+	default:
+	}
+}
+
+// drain drops the idle writers of a pool bounded by MaxWriters.
+func (p *writerPool) drain() {
+	for {
+		select {
+		case <-p.idle:
+		default:
+			return
+		}
+	}
+}
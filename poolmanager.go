@@ -0,0 +1,76 @@
+package gzip
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPoolManagerClosed is returned when a middleware is built on a closed
+// PoolManager.
+var ErrPoolManagerClosed = errors.New("gzip: pool manager is closed")
+
+// PoolManager shares writer pools between middleware instances, e.g. Gzip on
+// several route groups and WrapHandler, see WithPoolManager. It keeps one
+// pool per content coding and level, created on first use with its
+// PoolConfig, so MaxWriters bounds the idle writers of each pool.
+type PoolManager struct {
+	config PoolConfig
+
+	mu     sync.Mutex
+	pools  map[poolKey]*writerPool
+	closed bool
+}
+
+type poolKey struct {
+	encoding string
+	level    int
+}
+
+// NewPoolManager returns a PoolManager creating its pools with cfg.
+func NewPoolManager(cfg PoolConfig) *PoolManager {
+	return &PoolManager{
+		config: cfg,
+		pools:  make(map[poolKey]*writerPool),
+	}
+}
+
+// pool returns the gzip writer pool for level, creating it on first use.
+func (m *PoolManager) pool(level int) (*writerPool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrPoolManagerClosed
+	}
+	key := poolKey{encoding: "gzip", level: level}
+	p, ok := m.pools[key]
+	if !ok {
+		p = newWriterPool(level, m.config)
+		m.pools[key] = p
+	}
+	return p, nil
+}
+
+// Reset drops the idle writers of every pool, e.g. after a traffic peak, so
+// their memory can be reclaimed. Pools without MaxWriters keep theirs in a
+// sync.Pool, which the garbage collector empties by itself.
+func (m *PoolManager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.pools {
+		p.drain()
+	}
+}
+
+// Close drops the idle writers like Reset and stops pooling: writers still in
+// use are dropped when released, and building a middleware on m fails with
+// ErrPoolManagerClosed. Middleware already built keeps working with writers
+// that are allocated per response.
+func (m *PoolManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, p := range m.pools {
+		p.closed.Store(true)
+		p.drain()
+	}
+}
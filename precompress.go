@@ -0,0 +1,170 @@
+package gzip
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// compressBytes returns data gzip compressed at level, with comment in the
+// gzip header.
+func compressBytes(data []byte, level int, comment string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := newCompressor(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	gz.Comment = comment
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// precompressComment marks the gzip files PrecompressDir writes, so only
+// those are ever replaced as stale or removed as orphans.
+const precompressComment = "gin-contrib/gzip PrecompressDir"
+
+// PrecompressDir writes a <name>.gz sibling for every file under root worth
+// compressing at level, skipping the default excluded extensions, existing
+// .gz and .br files and files that do not shrink. Embedding root afterwards
+// lets StaticFS serve the siblings instead of compressing at startup. The
+// gzip-precompress command runs it from go generate:
+//
+//	//go:generate go run github.com/gin-contrib/gzip/cmd/gzip-precompress assets
+//
+// Siblings are written to a temporary file and renamed into place, so an
+// interrupted run or a server reading the directory never sees a truncated
+// one, and carry the modification time of their file: siblings that still
+// match it are kept as they are. Siblings PrecompressDir wrote whose file is
+// gone, or no longer shrinks, are removed. Use RebuildPrecompressedDir after
+// changing level, and PrecompressDirWithOptions to sync siblings to disk.
+func PrecompressDir(root string, level int) error {
+	return PrecompressDirWithOptions(root, PrecompressOptions{Level: level})
+}
+
+// RebuildPrecompressedDir is PrecompressDir rewriting every sibling, whether
+// up to date or not.
+func RebuildPrecompressedDir(root string, level int) error {
+	return PrecompressDirWithOptions(root, PrecompressOptions{Level: level, Rebuild: true})
+}
+
+// PrecompressOptions configures PrecompressDirWithOptions.
+type PrecompressOptions struct {
+	Level int
+	// Rebuild rewrites every sibling, whether up to date or not.
+	Rebuild bool
+	// Sync flushes every sibling to stable storage before renaming it into
+	// place, so that servers sharing the volume never see a torn sibling
+	// after a crash, at the cost of a slower run.
+	Sync bool
+}
+
+// PrecompressDirWithOptions is PrecompressDir configured by opts.
+func PrecompressDirWithOptions(root string, opts PrecompressOptions) error {
+	if err := validateLevel(opts.Level); err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := filepath.Ext(name)
+		if ext == ".gz" {
+			return removeOrphanSibling(name)
+		}
+		if ext == ".br" || DefaultExcludedExtentions.Contains(ext) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sibling, err := os.Stat(name + ".gz")
+		if !opts.Rebuild && err == nil && sibling.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		compressed, err := compressBytes(data, opts.Level, precompressComment)
+		if err != nil {
+			return err
+		}
+		if len(compressed) >= len(data) {
+			return removeGeneratedSibling(name + ".gz")
+		}
+		return writeSibling(name+".gz", compressed, info.ModTime(), opts.Sync)
+	})
+}
+
+// writeSibling atomically replaces name with data, stamped with modtime, and
+// syncs it to disk first if sync is set.
+func writeSibling(name string, data []byte, modtime time.Time, sync bool) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if sync {
+		if err = tmp.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmp.Name(), modtime, modtime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// removeOrphanSibling removes name, a .gz file, if PrecompressDir wrote it
+// for a file that no longer exists.
+func removeOrphanSibling(name string) error {
+	_, err := os.Stat(strings.TrimSuffix(name, ".gz"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return removeGeneratedSibling(name)
+}
+
+// removeGeneratedSibling removes name if it exists and PrecompressDir wrote
+// it, leaving any other gzip file alone.
+func removeGeneratedSibling(name string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	gz, err := newDecompressor(f)
+	generated := err == nil && gz.Header.Comment == precompressComment
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if !generated {
+		return nil
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,89 @@
+//go:build !gzipminimal
+
+package gzip
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// precompressedRequest reports whether the request may be answered from
+// PrecompressedFS: a GET or HEAD request that no route matches, or whose path
+// starts with PrecompressedPrefix.
+func (g *gzipHandler) precompressedRequest(c *gin.Context) bool {
+	if m := c.Request.Method; m != http.MethodGet && m != http.MethodHead {
+		return false
+	}
+	if c.FullPath() == "" {
+		return true
+	}
+	prefix := g.PrecompressedPrefix
+	return prefix != "" && strings.HasPrefix(path.Clean("/"+c.Request.URL.Path), prefix)
+}
+
+// servePrecompressed serves the .gz sibling of the requested file in
+// PrecompressedFS, reporting whether there was one.
+func (g *gzipHandler) servePrecompressed(c *gin.Context) bool {
+	name := path.Clean("/" + c.Request.URL.Path)
+	if strings.HasSuffix(c.Request.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	f, err := g.PrecompressedFS.Open(fsName(name) + ".gz")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	content, err := readSeeker(f)
+	if err != nil {
+		return false
+	}
+
+	serveGzipContent(c, name, fi.ModTime(), content)
+	c.Abort()
+	return true
+}
+
+// fsName turns name, a cleaned absolute URL path, into an fs.FS path.
+func fsName(name string) string {
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+// readSeeker returns f, or its content when f cannot seek, for
+// http.ServeContent.
+func readSeeker(f fs.File) (io.ReadSeeker, error) {
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+	data, err := io.ReadAll(f)
+	return bytes.NewReader(data), err
+}
+
+// serveGzipContent serves content, the gzip encoding of the file name, with
+// the Content-Type of name.
+func serveGzipContent(c *gin.Context, name string, modtime time.Time, content io.ReadSeeker) {
+	header := c.Writer.Header()
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		// ServeContent would sniff the compressed bytes
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Encoding", "gzip")
+	addVary(header, "Accept-Encoding")
+	http.ServeContent(c.Writer, c.Request, name, modtime, content)
+}
@@ -0,0 +1,53 @@
+package gzip
+
+import "time"
+
+// Presets tune compression alone for common kinds of responses: the level,
+// the smallest body worth compressing, the content types compressed and the
+// FlushPolicy. Unlike the modes they leave request decompression, stats and
+// precompressed files alone, so they combine with any of those. Options
+// given after a preset override it.
+
+// staticAssetContentTypes are the text based asset types PresetStaticAssets
+// compresses.
+var staticAssetContentTypes = []string{
+	"text/*", "application/javascript", "application/json", "application/manifest+json",
+	"application/xml", "application/wasm", "image/svg+xml", "font/ttf", "font/otf",
+}
+
+// PresetAPI compresses JSON, protobuf and text responses of at least 1 KiB at
+// BestSpeed, ending a deflate block on every Flush so streamed results such
+// as NDJSON reach clients at once.
+func PresetAPI() Option {
+	return func(o *Options) {
+		WithLevel(BestSpeed)(o)
+		o.IncludedContentTypes = NewIncludedContentTypes(apiContentTypes)
+		o.MinLength = 1024
+		o.FlushPolicy = FlushImmediate
+	}
+}
+
+// PresetStaticAssets compresses text based assets of at least 1 KiB at
+// BestCompression, which pays off for files served many times, and only
+// flushes the compressor when its buffer fills.
+func PresetStaticAssets() Option {
+	return func(o *Options) {
+		WithLevel(BestCompression)(o)
+		o.IncludedContentTypes = NewIncludedContentTypes(staticAssetContentTypes)
+		o.MinLength = 1024
+		o.FlushPolicy = FlushBuffered
+	}
+}
+
+// PresetProxy compresses proxied responses of at least 1 KiB at BestSpeed,
+// passing through bodies that start like a compressed format whatever their
+// Content-Type claims, and ends a deflate block every 100ms so streamed
+// upstream responses make progress however often the proxy flushes.
+func PresetProxy() Option {
+	return func(o *Options) {
+		WithLevel(BestSpeed)(o)
+		o.MinLength = 1024
+		o.ContentSniffing = true
+		o.FlushPolicy = FlushInterval(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,52 @@
+package gzip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+)
+
+// compressingKey marks request contexts whose response the middleware
+// compresses.
+type compressingKey struct{}
+
+func isCompressing(ctx context.Context) bool {
+	v, _ := ctx.Value(compressingKey{}).(bool)
+	return v
+}
+
+// WrapDirector wraps the Director of an httputil.ReverseProxy used behind the
+// middleware so the upstream Accept-Encoding follows its policy: when the
+// middleware compresses the response, the upstream is asked for identity so
+// the body is compressed exactly once; otherwise the client's Accept-Encoding
+// passes through unchanged.
+func WrapDirector(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		if isCompressing(req.Context()) {
+			req.Header.Set("Accept-Encoding", "identity")
+		}
+	}
+}
+
+// ProxyDirector makes rp ask its upstream for identity bodies whatever the
+// client accepts, replacing the outbound Accept-Encoding, so the middleware is
+// the only one compressing responses. Unlike WrapDirector it also applies to
+// clients the middleware does not compress for, which then get identity
+// bodies too. It wraps rp.Rewrite when set and rp.Director otherwise.
+func ProxyDirector(rp *httputil.ReverseProxy) {
+	if rewrite := rp.Rewrite; rewrite != nil {
+		rp.Rewrite = func(pr *httputil.ProxyRequest) {
+			rewrite(pr)
+			pr.Out.Header.Set("Accept-Encoding", "identity")
+		}
+		return
+	}
+	director := rp.Director
+	rp.Director = func(req *http.Request) {
+		if director != nil {
+			director(req)
+		}
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+}
@@ -0,0 +1,158 @@
+//go:build soak
+
+package gzip
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run with: go test -tags soak -run TestSoak -soak.duration 5m
+var soakDuration = flag.Duration("soak.duration", time.Minute, "how long TestSoak keeps the server under load")
+
+const soakMaxWriters = 8
+
+func TestSoak(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	var handlerErrors atomic.Int64
+	handler := newGzipHandler(DefaultCompression, WithPoolConfig(0, soakMaxWriters, 0))
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		handlerErrors.Add(int64(len(c.Errors)))
+	}, handler.Handle)
+	router.GET("/plain", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat(testResponse, 100))
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		for i := 0; i < 20; i++ {
+			if c.Request.Context().Err() != nil {
+				return
+			}
+			c.String(http.StatusOK, testResponse)
+			c.Writer.Flush()
+			time.Sleep(time.Millisecond)
+		}
+	})
+	router.GET("/hijack", func(c *gin.Context) {
+		conn, buf, err := c.Writer.Hijack()
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+		_ = buf.Flush()
+	})
+
+	baseGoroutines := goroutines()
+	baseFDs := openFDs(t)
+
+	server := httptest.NewServer(router)
+	deadline := time.Now().Add(*soakDuration)
+	var requests atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < 4*runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; time.Now().Before(deadline); i++ {
+				soakRequest(t, server, i)
+				requests.Add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	server.Client().CloseIdleConnections()
+	server.Close()
+	t.Logf("served %d requests", requests.Load())
+
+	assert.Zero(t, handlerErrors.Load())
+	assert.LessOrEqual(t, len(handler.gzPool.idle), soakMaxWriters)
+	assert.Zero(t, outstandingWriters.Load())
+	// polled by hand, assert.Eventually runs the condition on extra goroutines
+	assert.True(t, settles(func() bool { return goroutines() <= baseGoroutines }), "goroutines leaked")
+	if baseFDs >= 0 {
+		assert.True(t, settles(func() bool { return openFDs(t) <= baseFDs }), "file descriptors leaked")
+	}
+}
+
+// soakRequest sends one of several request kinds, chosen by i.
+func soakRequest(t *testing.T, server *httptest.Server, i int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := []string{"/plain", "/stream", "/stream", "/hijack"}[i%4]
+	abort := i%4 == 2
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+path, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := server.Client().Transport.RoundTrip(req)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+		return
+	}
+	defer res.Body.Close()
+
+	switch {
+	case abort:
+		// the client goes away in the middle of the stream
+		_, _ = res.Body.Read(make([]byte, 16))
+		cancel()
+	case path == "/hijack":
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, "ok", string(body))
+	default:
+		gr, err := gzip.NewReader(res.Body)
+		if !assert.NoError(t, err, path) {
+			return
+		}
+		_, err = io.Copy(io.Discard, gr)
+		assert.NoError(t, err, path)
+	}
+}
+
+// settles reports whether cond becomes true within a few seconds.
+func settles(cond func() bool) bool {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return cond()
+}
+
+// goroutines counts the goroutines in a full stack dump, which unlike
+// runtime.NumGoroutine leaves out those owned by the runtime.
+func goroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count("\n"+string(buf), "\ngoroutine ")
+}
+
+// openFDs returns the number of open file descriptors, or -1 where it cannot
+// be determined.
+func openFDs(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Log("file descriptor checks disabled:", err)
+		return -1
+	}
+	return len(entries)
+}
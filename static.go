@@ -0,0 +1,143 @@
+//go:build !gzipminimal
+
+package gzip
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Static serves the files under root at relativePath of group, like
+// gin.RouterGroup.Static, compressing them with the options Gzip takes at
+// the level WithLevel sets, DefaultCompression by default. Unlike wrapping
+// gin.Static with Gzip:
+//
+//   - missing files and directories without an index.html are a plain 404,
+//     decided before anything is compressed
+//   - a file with a <name>.gz sibling is served from it when the client
+//     accepts gzip
+//   - files left uncompressed are handed to the connection, which sends them
+//     with sendfile(2) where the platform supports it
+//
+// Invalid options make Static panic.
+func Static(group *gin.RouterGroup, relativePath, root string, options ...Option) gin.IRoutes {
+	if root == "" {
+		root = "."
+	}
+	return StaticFromFS(group, relativePath, os.DirFS(root), options...)
+}
+
+// StaticFromFS is Static serving the files of fsys. Files that cannot seek,
+// which embed.FS and os.DirFS files can, are read into memory to be served.
+func StaticFromFS(group *gin.RouterGroup, relativePath string, fsys fs.FS, options ...Option) gin.IRoutes {
+	if strings.Contains(relativePath, ":") || strings.Contains(relativePath, "*") {
+		panic("gzip: URL parameters can not be used when serving a static folder")
+	}
+	s := &staticServer{
+		handler: newGzipHandler(DefaultCompression, options...),
+		fs:      fsys,
+	}
+	pattern := path.Join(relativePath, "/*filepath")
+	group.GET(pattern, s.serve)
+	return group.HEAD(pattern, s.serve)
+}
+
+type staticServer struct {
+	handler *gzipHandler
+	fs      fs.FS
+}
+
+func (s *staticServer) serve(c *gin.Context) {
+	g := s.handler
+	name := path.Clean("/" + c.Param("filepath"))
+	f, fi := s.open(name)
+	if f != nil && fi.IsDir() {
+		f.Close()
+		if !strings.HasSuffix(c.Request.URL.Path, "/") {
+			// relative links in the index resolve against the directory
+			c.Redirect(http.StatusMovedPermanently, path.Base(c.Request.URL.Path)+"/")
+			return
+		}
+		name = path.Join(name, "index.html")
+		f, fi = s.open(name)
+	}
+	if f == nil || fi.IsDir() {
+		if f != nil {
+			f.Close()
+		}
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	reason := g.requestSkipReason(c)
+	if reason == "" {
+		if gz, gzi := s.open(name + ".gz"); gz != nil {
+			defer gz.Close()
+			if !gzi.IsDir() {
+				if content, err := readSeeker(gz); err == nil {
+					serveGzipContent(c, name, gzi.ModTime(), content)
+					recordEncodingSource(c, true)
+					return
+				}
+			}
+		}
+	}
+	content, err := readSeeker(f)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if reason == "" && !g.acquireSlot() {
+		reason = ReasonConcurrency
+	}
+	if reason != "" {
+		g.emit(c, Event{Kind: EventSkipped, Reason: reason})
+		w := http.ResponseWriter(c.Writer)
+		if rf, ok := baseWriter(c.Writer).(io.ReaderFrom); ok && wrapsBase(c.Writer) {
+			w = sendfileWriter{c.Writer, rf}
+		}
+		http.ServeContent(w, c.Request, name, fi.ModTime(), content)
+		recordEncodingSource(c, false)
+		return
+	}
+	if g.slots != nil {
+		defer func() { <-g.slots }()
+	}
+	g.compress(c, func() {
+		http.ServeContent(c.Writer, c.Request, name, fi.ModTime(), content)
+	})
+}
+
+// open opens the file name, returning nil when it cannot be opened.
+func (s *staticServer) open(name string) (fs.File, fs.FileInfo) {
+	f, err := s.fs.Open(fsName(name))
+	if err != nil {
+		return nil, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil
+	}
+	return f, fi
+}
+
+// sendfileWriter hands bodies copied by http.ServeContent to the connection's
+// ReadFrom, which gin's writer hides. gin does not count bytes sent that way
+// in Size.
+type sendfileWriter struct {
+	gin.ResponseWriter
+	rf io.ReaderFrom
+}
+
+func (w sendfileWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.WriteHeaderNow()
+	return w.rf.ReadFrom(r)
+}
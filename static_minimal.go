@@ -0,0 +1,36 @@
+//go:build gzipminimal
+
+package gzip
+
+import (
+	"io/fs"
+
+	"github.com/gin-gonic/gin"
+)
+
+const errStaticMinimal = "gzip: static files are not available in gzipminimal builds"
+
+// Static panics: gzipminimal builds do not serve static files.
+func Static(*gin.RouterGroup, string, string, ...Option) gin.IRoutes {
+	panic(errStaticMinimal)
+}
+
+// StaticFromFS panics: gzipminimal builds do not serve static files.
+func StaticFromFS(*gin.RouterGroup, string, fs.FS, ...Option) gin.IRoutes {
+	panic(errStaticMinimal)
+}
+
+// StaticFS panics: gzipminimal builds do not serve static files.
+func StaticFS(fs.FS, ...Option) gin.HandlerFunc {
+	panic(errStaticMinimal)
+}
+
+// precompressedRequest and servePrecompressed are never reached: New rejects
+// PrecompressedFS in gzipminimal builds.
+func (g *gzipHandler) precompressedRequest(*gin.Context) bool {
+	return false
+}
+
+func (g *gzipHandler) servePrecompressed(*gin.Context) bool {
+	return false
+}
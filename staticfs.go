@@ -0,0 +1,162 @@
+//go:build !gzipminimal
+
+package gzip
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// immutableCacheControl is sent with every file StaticFS serves.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// StaticFS returns a handler serving the files of fsys, typically an
+// embed.FS, on a route ending in a *filepath parameter, e.g.
+//
+//	router.GET("/assets/*filepath", gzip.StaticFS(assets))
+//
+// Every file is loaded into memory when StaticFS is called, along with its
+// encodings: a <name>.gz or <name>.br sibling in fsys, such as the ones
+// PrecompressDir writes from go generate, is used as it is, and other files
+// are compressed with the level and options Gzip takes, except excluded
+// extensions, files under MinLength and files that do not shrink. Brotli is
+// only served from .br siblings. Each request gets the best encoding the
+// client accepts, with a strong ETag naming it and an immutable
+// Cache-Control, so only serve files whose names change with their content.
+// Invalid options or files that cannot be read make StaticFS panic.
+func StaticFS(fsys fs.FS, options ...Option) gin.HandlerFunc {
+	s := &staticFSServer{
+		handler: newGzipHandler(DefaultCompression, options...),
+		assets:  make(map[string]*staticAsset),
+	}
+	if err := s.load(fsys); err != nil {
+		panic(err)
+	}
+	return s.serve
+}
+
+type staticFSServer struct {
+	handler *gzipHandler
+	assets  map[string]*staticAsset // by absolute path, directories ending in a slash
+}
+
+// staticAsset is a file loaded by StaticFS with its encodings, nil when the
+// file has none.
+type staticAsset struct {
+	contentType string
+	etag        string // unquoted
+	identity    []byte
+	gzip        []byte
+	br          []byte
+}
+
+func (s *staticFSServer) load(fsys fs.FS) error {
+	files := make(map[string][]byte)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		files[name] = data
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("gzip: static files: %w", err)
+	}
+
+	g := s.handler
+	level := DefaultCompression
+	if g.Level != nil {
+		level = *g.Level
+	}
+	for name, data := range files {
+		base, ok := strings.CutSuffix(name, ".gz")
+		if !ok {
+			base, ok = strings.CutSuffix(name, ".br")
+		}
+		if _, variant := files[base]; ok && variant {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		asset := &staticAsset{
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			etag:        hex.EncodeToString(sum[:8]),
+			identity:    data,
+			gzip:        files[name+".gz"],
+			br:          files[name+".br"],
+		}
+		if asset.contentType == "" {
+			asset.contentType = http.DetectContentType(data)
+		}
+		if asset.gzip == nil && len(data) >= g.MinLength && !g.ExcludedExtensions.Contains(path.Ext(name)) {
+			if asset.gzip, err = compressBytes(data, level, ""); err != nil {
+				return err
+			}
+			if len(asset.gzip) >= len(data) {
+				asset.gzip = nil
+			}
+		}
+		s.assets["/"+name] = asset
+		if dir, ok := strings.CutSuffix(name, "index.html"); ok && (dir == "" || strings.HasSuffix(dir, "/")) {
+			s.assets["/"+dir] = asset
+		}
+	}
+	return nil
+}
+
+func (s *staticFSServer) serve(c *gin.Context) {
+	g := s.handler
+	name := c.Param("filepath")
+	if name == "" {
+		name = c.Request.URL.Path
+	}
+	dir := strings.HasSuffix(name, "/")
+	name = path.Clean("/" + name)
+	if dir && name != "/" {
+		name += "/"
+	}
+	asset := s.assets[name]
+	if asset == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	header := c.Writer.Header()
+	if asset.gzip != nil || asset.br != nil {
+		addVary(header, "Accept-Encoding")
+	}
+	content, coding := asset.identity, ""
+	reason := g.requestSkipReason(c)
+	accept := cachedAcceptEncoding(c.Request.Header.Values("Accept-Encoding"))
+	switch {
+	case asset.br != nil && (reason == "" || reason == ReasonAcceptEncoding) &&
+		accept.allowsBrotli(g.AllowWildcardAccept):
+		content, coding = asset.br, "br"
+	case asset.gzip != nil && reason == "":
+		content, coding = asset.gzip, "gzip"
+	case reason != "":
+		g.emit(c, Event{Kind: EventSkipped, Reason: reason})
+	}
+
+	header.Set("Content-Type", asset.contentType)
+	header.Set("Cache-Control", immutableCacheControl)
+	if coding == "" {
+		header.Set("ETag", `"`+asset.etag+`"`)
+	} else {
+		header.Set("Content-Encoding", coding)
+		header.Set("ETag", `"`+asset.etag+"-"+coding+`"`)
+		c.Set(EncodingSourceKey, EncodingSourceLocal)
+		c.Set(EncodingKey, coding)
+	}
+	http.ServeContent(c.Writer, c.Request, name, time.Time{}, bytes.NewReader(content))
+}
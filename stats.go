@@ -0,0 +1,53 @@
+package gzip
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stats are the cumulative counters recorded by middlewares built with
+// WithStats, as rendered by StatsHandler.
+type Stats struct {
+	Compressed         int64                 `json:"compressed"`
+	Skipped            map[string]int64      `json:"skipped"`
+	DecompressFailures int64                 `json:"decompress_failures"`
+	OriginalBytes      int64                 `json:"original_bytes"`
+	CompressedBytes    int64                 `json:"compressed_bytes"`
+	BytesSaved         int64                 `json:"bytes_saved"`
+	AverageRatio       float64               `json:"average_ratio"`
+	Routes             map[string]RouteStats `json:"routes"`
+}
+
+// RouteStats are the counters of compressed responses for one route.
+type RouteStats struct {
+	Compressed      int64   `json:"compressed"`
+	OriginalBytes   int64   `json:"original_bytes"`
+	CompressedBytes int64   `json:"compressed_bytes"`
+	BytesSaved      int64   `json:"bytes_saved"`
+	AverageRatio    float64 `json:"average_ratio"`
+}
+
+// ReadStats returns a snapshot of the counters recorded so far. Gzipminimal
+// builds record none.
+func ReadStats() Stats {
+	return readStats()
+}
+
+// StatsHandler renders ReadStats as JSON, e.g. for an internal debug route.
+// It only answers requests coming from one of the networks trusted, matched
+// like WithTrustedNetworks, and 404 to others, so without networks it answers
+// no one. An invalid CIDR makes StatsHandler panic.
+func StatsHandler(trusted ...string) gin.HandlerFunc {
+	networks, err := newNetworks("trusted", trusted)
+	if err != nil {
+		panic(err)
+	}
+	return func(c *gin.Context) {
+		if !clientFromNetworks(networks, c) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, ReadStats())
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !gzipminimal
+
+package gzip
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type statsCounters struct {
+	compressed      atomic.Int64
+	originalBytes   atomic.Int64
+	compressedBytes atomic.Int64
+}
+
+func (s *statsCounters) add(original, compressed int) {
+	s.compressed.Add(1)
+	s.originalBytes.Add(int64(original))
+	s.compressedBytes.Add(int64(compressed))
+}
+
+var stats struct {
+	statsCounters
+	decompressFailures atomic.Int64
+	skipped            sync.Map // reason -> *atomic.Int64
+	routes             sync.Map // route -> *statsCounters
+}
+
+func recordCompressed(route string, original, compressed int) {
+	stats.add(original, compressed)
+	counters, ok := stats.routes.Load(route)
+	if !ok {
+		counters, _ = stats.routes.LoadOrStore(route, new(statsCounters))
+	}
+	counters.(*statsCounters).add(original, compressed)
+}
+
+func recordEvent(e Event) {
+	switch e.Kind {
+	case EventSkipped:
+		n, ok := stats.skipped.Load(e.Reason)
+		if !ok {
+			n, _ = stats.skipped.LoadOrStore(e.Reason, new(atomic.Int64))
+		}
+		n.(*atomic.Int64).Add(1)
+	case EventDecompressFailed:
+		stats.decompressFailures.Add(1)
+	}
+}
+
+func ratio(original, compressed int64) float64 {
+	if compressed == 0 {
+		return 0
+	}
+	return float64(original) / float64(compressed)
+}
+
+// readStats returns a snapshot of the counters recorded so far.
+func readStats() Stats {
+	s := Stats{
+		Compressed:         stats.compressed.Load(),
+		Skipped:            map[string]int64{},
+		DecompressFailures: stats.decompressFailures.Load(),
+		OriginalBytes:      stats.originalBytes.Load(),
+		CompressedBytes:    stats.compressedBytes.Load(),
+		Routes:             map[string]RouteStats{},
+	}
+	s.BytesSaved = s.OriginalBytes - s.CompressedBytes
+	s.AverageRatio = ratio(s.OriginalBytes, s.CompressedBytes)
+	stats.skipped.Range(func(reason, n interface{}) bool {
+		s.Skipped[reason.(string)] = n.(*atomic.Int64).Load()
+		return true
+	})
+	stats.routes.Range(func(route, counters interface{}) bool {
+		c := counters.(*statsCounters)
+		r := RouteStats{
+			Compressed:      c.compressed.Load(),
+			OriginalBytes:   c.originalBytes.Load(),
+			CompressedBytes: c.compressedBytes.Load(),
+		}
+		r.BytesSaved = r.OriginalBytes - r.CompressedBytes
+		r.AverageRatio = ratio(r.OriginalBytes, r.CompressedBytes)
+		s.Routes[route.(string)] = r
+		return true
+	})
+	return s
+}
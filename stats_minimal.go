@@ -0,0 +1,13 @@
+//go:build gzipminimal
+
+package gzip
+
+// recordCompressed and recordEvent record nothing: gzipminimal builds keep no
+// WithStats counters.
+func recordCompressed(string, int, int) {}
+
+func recordEvent(Event) {}
+
+func readStats() Stats {
+	return Stats{Skipped: map[string]int64{}, Routes: map[string]RouteStats{}}
+}
@@ -0,0 +1,67 @@
+package gzip
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamJSONPages writes the pages returned by next, followed by summary when
+// it is not nil, as newline-delimited JSON. The response is flushed after
+// every page so each one reaches the client as soon as it is produced, even
+// when compressed. It stops early with the request context's error when the
+// client goes away.
+func StreamJSONPages(c *gin.Context, code int, next func() (page interface{}, ok bool), summary interface{}) error {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(code)
+
+	enc := json.NewEncoder(c.Writer)
+	for {
+		if err := c.Request.Context().Err(); err != nil {
+			return err
+		}
+		page, ok := next()
+		if !ok {
+			break
+		}
+		if err := enc.Encode(page); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+	}
+
+	if summary != nil {
+		if err := enc.Encode(summary); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+	}
+	return nil
+}
+
+// Streamer returns a writer for streaming the response of c event by event,
+// e.g. from a c.Stream callback. Every Write ends its own deflate block and is
+// flushed, whatever WithFlushPolicy says, so each server-sent event can be
+// decoded as soon as it arrives, while the body stays a single gzip member.
+// Responses the middleware does not compress are flushed after every Write.
+func Streamer(c *gin.Context) io.Writer {
+	return streamer{c}
+}
+
+type streamer struct {
+	c *gin.Context
+}
+
+func (s streamer) Write(p []byte) (int, error) {
+	w := s.c.Writer
+	n, err := w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if gw, ok := w.(*gzipWriter); ok {
+		return n, gw.flushBlock()
+	}
+	w.Flush()
+	return n, nil
+}
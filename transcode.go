@@ -0,0 +1,202 @@
+package gzip
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// transcodeWriter decodes a gzip-encoded response for a client that does not
+// accept gzip, see Options.Transcode. The encoded body is handed to a decoder
+// running in its own goroutine, so it streams instead of being buffered. The
+// decoder never touches the response: it passes what it decodes back to the
+// handler's goroutine, which waits on every chunk until the decoder asks for
+// the next one.
+type transcodeWriter struct {
+	gin.ResponseWriter
+
+	decided bool
+
+	// in carries the encoded chunks to the decoder, which asks for each on
+	// want and passes the decoded body back on out, one write at a time,
+	// each acknowledged on written. done carries the result of decode.
+	in      chan []byte
+	want    chan struct{}
+	out     chan []byte
+	written chan error
+	done    chan error
+
+	// wanting is set while the decoder waits on in, finished once it has
+	// returned err.
+	wanting  bool
+	finished bool
+	err      error
+}
+
+// decide checks the headers set by the handler once, before anything is sent,
+// and starts decoding when the body is gzip and nothing else.
+func (t *transcodeWriter) decide() {
+	if t.decided {
+		return
+	}
+	t.decided = true
+
+	header := t.Header()
+	codings, err := parseCodings(header.Values("Content-Encoding"))
+	if err != nil || len(codings) != 1 || (codings[0] != "gzip" && codings[0] != "x-gzip") {
+		return
+	}
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	addVary(header, "Accept-Encoding")
+
+	t.in = make(chan []byte)
+	t.want = make(chan struct{})
+	t.out = make(chan []byte)
+	t.written = make(chan error)
+	t.done = make(chan error, 1)
+	go func() {
+		t.done <- t.decode()
+	}()
+}
+
+// decode runs in the decoder's goroutine.
+func (t *transcodeWriter) decode() error {
+	zr, err := newDecompressor(&transcodeInput{t: t})
+	if errors.Is(err, io.EOF) {
+		// no body, e.g. a HEAD request
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(transcodeOutput{t}, zr)
+	return err
+}
+
+// transcodeInput reads the chunks handed to the decoder.
+type transcodeInput struct {
+	t     *transcodeWriter
+	chunk []byte
+	eof   bool
+}
+
+func (r *transcodeInput) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		r.t.want <- struct{}{}
+		chunk, ok := <-r.t.in
+		r.chunk, r.eof = chunk, !ok
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// transcodeOutput passes the decoded body to the handler's goroutine.
+type transcodeOutput struct {
+	t *transcodeWriter
+}
+
+func (w transcodeOutput) Write(p []byte) (int, error) {
+	w.t.out <- p
+	if err := <-w.t.written; err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// pump writes what the decoder passes back until it asks for more input or
+// returns.
+func (t *transcodeWriter) pump() {
+	for {
+		select {
+		case <-t.want:
+			t.wanting = true
+			return
+		case p := <-t.out:
+			_, err := t.ResponseWriter.Write(p)
+			t.written <- err
+		case t.err = <-t.done:
+			t.finished = true
+			return
+		}
+	}
+}
+
+func (t *transcodeWriter) Write(p []byte) (int, error) {
+	t.decide()
+	if t.in == nil {
+		return t.ResponseWriter.Write(p)
+	}
+	if !t.wanting && !t.finished {
+		t.pump()
+	}
+	if t.finished {
+		if t.err == nil {
+			return 0, io.ErrClosedPipe
+		}
+		return 0, t.err
+	}
+	t.wanting = false
+	t.in <- p
+	t.pump()
+	if t.finished && t.err != nil {
+		return 0, t.err
+	}
+	return len(p), nil
+}
+
+func (t *transcodeWriter) WriteString(s string) (int, error) {
+	return t.Write([]byte(s))
+}
+
+func (t *transcodeWriter) WriteHeaderNow() {
+	t.decide()
+	t.ResponseWriter.WriteHeaderNow()
+}
+
+func (t *transcodeWriter) Flush() {
+	t.decide()
+	if _, ok := baseWriter(t.ResponseWriter).(http.Flusher); !ok {
+		// gin's Flush would panic
+		t.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	t.ResponseWriter.Flush()
+}
+
+// finish decodes the rest of the body once the handler has returned.
+func (t *transcodeWriter) finish() error {
+	t.decide()
+	if t.in == nil {
+		return nil
+	}
+	if !t.wanting && !t.finished {
+		t.pump()
+	}
+	if !t.finished {
+		t.wanting = false
+		close(t.in)
+		t.pump()
+	}
+	return t.err
+}
+
+// transcode runs the rest of the chain with the response decoded by a
+// transcodeWriter.
+func transcode(c *gin.Context) {
+	tw := &transcodeWriter{ResponseWriter: c.Writer}
+	c.Writer = tw
+	defer func() {
+		c.Writer = tw.ResponseWriter
+		if err := tw.finish(); err != nil {
+			_ = c.Error(err)
+		}
+	}()
+	c.Next()
+}
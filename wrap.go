@@ -0,0 +1,26 @@
+package gzip
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WrapHandler compresses the responses of a plain net/http handler with the
+// same level and options Gzip takes, for stacks that mix gin with standard
+// library muxes.
+func WrapHandler(h http.Handler, level int, options ...Option) http.Handler {
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Next()
+		// gin only sends the status of NoRoute handlers that wrote a body,
+		// and writes its own 404 page otherwise
+		c.Writer.WriteHeaderNow()
+	}, Gzip(level, options...))
+	engine.NoRoute(func(c *gin.Context) {
+		// gin presets 404 for NoRoute handlers; start from net/http's default
+		c.Status(http.StatusOK)
+		h.ServeHTTP(c.Writer, c.Request)
+	})
+	return engine
+}